@@ -0,0 +1,106 @@
+package ikisocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrorAckNotRequested The inbound message did not provide
+	// an id, so it cannot be acknowledged
+	ErrorAckNotRequested = errors.New("the message does not expect an acknowledgement")
+)
+
+type safeAcks struct {
+	sync.Mutex
+	// Reply channels of the pending acknowledgements
+	list map[uint64]chan []byte
+}
+
+func (a *safeAcks) set(id uint64, reply chan []byte) {
+	a.Lock()
+	if a.list == nil {
+		a.list = make(map[uint64]chan []byte)
+	}
+	a.list[id] = reply
+	a.Unlock()
+}
+
+func (a *safeAcks) pop(id uint64) (chan []byte, bool) {
+	a.Lock()
+	defer a.Unlock()
+	reply, ok := a.list[id]
+	delete(a.list, id)
+	return reply, ok
+}
+
+func (a *safeAcks) len() int {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.list)
+}
+
+// EmitWithAck Emit the event with its payload as an envelope with an id
+// and wait for the client reply, the client acknowledges sending back
+//
+//	{"ack": <id>, "data": <reply>}
+//
+// Returns the reply data, the context error when it expires before the
+// reply or ErrorInvalidConnection if the connection is closed.
+func (kws *Websocket) EmitWithAck(ctx context.Context, event string, payload []byte) ([]byte, error) {
+	id := atomic.AddUint64(&kws.lastID, 1)
+	reply := make(chan []byte, 1)
+
+	kws.acks.set(id, reply)
+	defer kws.acks.pop(id)
+
+	kws.write(TextMessage, envelope{Event: event, ID: id}.encode(payload))
+
+	select {
+	case data := <-reply:
+		return data, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-kws.done:
+		return nil, ErrorInvalidConnection
+	}
+}
+
+// Deliver the message to the pending EmitWithAck,
+// returns false if the message is not a reply
+func (kws *Websocket) resolveAck(msg []byte) bool {
+	if kws.acks.len() == 0 {
+		return false
+	}
+
+	e, ok := decodeEnvelope(msg)
+	if !ok || e.Ack == 0 {
+		return false
+	}
+
+	reply, ok := kws.acks.pop(e.Ack)
+	if !ok {
+		return false
+	}
+	reply <- e.data()
+	return true
+}
+
+// Ack Reply to an inbound envelope that provided an id,
+// the client receives {"ack": <id>, "data": <data>}
+func (ep *EventPayload) Ack(data []byte) error {
+	if ep.ackID == 0 {
+		return ErrorAckNotRequested
+	}
+	if !ep.Kws.IsAlive() {
+		return ErrorInvalidConnection
+	}
+
+	frame := envelope{Ack: ep.ackID}.encode(data)
+	// sent again if the client repeats the message
+	ep.Kws.seen.acked(ep.ackID, frame)
+	ep.Kws.write(TextMessage, frame)
+	return nil
+}
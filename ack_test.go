@@ -0,0 +1,106 @@
+package ikisocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmitWithAck(t *testing.T) {
+	h := NewHub()
+
+	connected := make(chan *Websocket, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		connected <- kws
+	}))
+	defer shutdown()
+
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	kws := <-connected
+
+	// client answering to every request
+	go func() {
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var e envelope
+			if json.Unmarshal(msg, &e) != nil || e.Event != "question" {
+				continue
+			}
+			_ = conn.WriteJSON(map[string]interface{}{
+				"ack":  e.ID,
+				"data": "answer to " + string(e.data()),
+			})
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := kws.EmitWithAck(ctx, "question", []byte("life"))
+	require.Nil(t, err)
+	require.Equal(t, "answer to life", string(reply))
+
+	reply, err = kws.EmitWithAck(ctx, "question", []byte(`{"n":42}`))
+	require.Nil(t, err)
+	require.Equal(t, `answer to {"n":42}`, string(reply))
+}
+
+func TestEmitWithAckTimeout(t *testing.T) {
+	h := NewHub()
+
+	connected := make(chan *Websocket, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		connected <- kws
+	}))
+	defer shutdown()
+
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	kws := <-connected
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := kws.EmitWithAck(ctx, "question", []byte("life"))
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Equal(t, 0, kws.acks.len())
+}
+
+func TestEventPayload_Ack(t *testing.T) {
+	h := NewHub(Config{Envelope: true})
+
+	h.On(EventMessage, func(payload *EventPayload) {
+		_ = payload.Ack([]byte("pong " + string(payload.Data)))
+	})
+
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {}))
+	defer shutdown()
+
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	require.Nil(t, conn.WriteMessage(TextMessage, []byte(`{"id":5,"data":"ping"}`)))
+
+	var e envelope
+	require.Nil(t, conn.ReadJSON(&e))
+	require.Equal(t, uint64(5), e.Ack)
+	require.Equal(t, "pong ping", string(e.data()))
+
+	payload := EventPayload{Kws: createWS()}
+	require.Equal(t, ErrorAckNotRequested, payload.Ack(nil))
+}
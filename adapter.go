@@ -0,0 +1,196 @@
+package ikisocket
+
+import (
+	"context"
+	"sync"
+)
+
+// AdapterMessageKind Kind of the message exchanged between the nodes
+type AdapterMessageKind int
+
+const (
+	// AdapterBroadcast Emit the data to all the connections
+	AdapterBroadcast AdapterMessageKind = iota + 1
+	// AdapterEmit Emit the data to the connection with the Target uuid
+	AdapterEmit
+	// AdapterRoom Emit the data to the members of the Target room
+	AdapterRoom
+	// AdapterFire Fire the Event on all the connections
+	AdapterFire
+	// AdapterPresence The Target user is online on the node, Event is
+	// EventOnline or EventOffline and Data the JSON list of its rooms
+	AdapterPresence
+	// AdapterUser Emit the data to the connections of the Target user
+	AdapterUser
+	// AdapterPresenceSync All the users of the node, Data is the JSON
+	// object of their rooms by user id
+	AdapterPresenceSync
+	// AdapterPresenceRequest Ask the nodes for their AdapterPresenceSync
+	AdapterPresenceRequest
+)
+
+// AdapterMessage Message exchanged between the nodes of a cluster
+type AdapterMessage struct {
+	// Kind of the message
+	Kind AdapterMessageKind `json:"kind"`
+	// Id of the publishing node
+	Node string `json:"node"`
+	// Id of the recipient node, empty for all the nodes
+	To string `json:"to,omitempty"`
+	// Connection uuid, room name or user id addressed by the message
+	Target string `json:"target,omitempty"`
+	// Name of the fired event
+	Event string `json:"event,omitempty"`
+	// Message data
+	Data []byte `json:"data,omitempty"`
+	// Message type
+	MType int `json:"mtype,omitempty"`
+	// Connection uuids excluded from a room emit
+	Except []string `json:"except,omitempty"`
+}
+
+// Adapter Distributes the messages between the nodes of a cluster,
+// so Broadcast/EmitTo/EmitToRoom/Fire also reach the connections
+// registered in the hubs of the other nodes
+type Adapter interface {
+	// Publish Send the message to the other nodes,
+	// only to the To node when it is set
+	Publish(ctx context.Context, msg AdapterMessage) error
+	// Subscribe Deliver to the handler the messages published
+	// for all the nodes or addressed to the given node
+	Subscribe(node string, handler func(msg AdapterMessage)) error
+	// Close Stop the subscription
+	Close() error
+}
+
+// UseAdapter Connect the hub to the other nodes through the adapter
+func (h *Hub) UseAdapter(adapter Adapter) error {
+	if err := adapter.Subscribe(h.node, h.deliver); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.adapter = adapter
+	presence := h.presence
+	h.mu.Unlock()
+
+	if presence != nil {
+		presence.join()
+		presence.heartbeat()
+	}
+	return nil
+}
+
+// NodeID Unique id of the hub in the cluster
+func (h *Hub) NodeID() string {
+	return h.node
+}
+
+func (h *Hub) getAdapter() Adapter {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.adapter
+}
+
+// Send the message to the other nodes, if there is an adapter
+func (h *Hub) publish(msg AdapterMessage) error {
+	adapter := h.getAdapter()
+	if adapter == nil {
+		return nil
+	}
+	msg.Node = h.node
+	return adapter.Publish(context.Background(), msg)
+}
+
+// Handle a message published by another node
+func (h *Hub) deliver(msg AdapterMessage) {
+	// avoid echoes of the own publications
+	if msg.Node == h.node {
+		return
+	}
+
+	switch msg.Kind {
+	case AdapterBroadcast:
+		h.broadcastLocal(msg.Data, msg.MType)
+	case AdapterEmit:
+		_ = h.emitLocal(msg.Target, msg.Data, msg.MType)
+	case AdapterRoom:
+		_ = h.emitToRoomLocal(msg.Target, msg.Data, msg.Except, msg.MType)
+	case AdapterFire:
+		h.fireGlobalEvent(msg.Event, msg.Data, nil)
+	case AdapterPresence:
+		h.Presence().apply(msg)
+	case AdapterUser:
+		h.emitToUserLocal(msg.Target, msg.Data, msg.MType)
+	case AdapterPresenceSync:
+		h.Presence().sync(msg)
+	case AdapterPresenceRequest:
+		// a node without presence has no users to send
+		if p := h.getPresence(); p != nil {
+			_ = p.snapshot(msg.Node)
+		}
+	}
+}
+
+// UseAdapter Connect the default hub to the other nodes through the adapter
+func UseAdapter(adapter Adapter) error {
+	return defaultHub.UseAdapter(adapter)
+}
+
+// MemoryBus In-process message bus connecting the hubs of the same
+// process as if they were different nodes, useful for tests
+type MemoryBus struct {
+	sync.RWMutex
+	subscribers map[*memoryAdapter]string
+}
+
+type memoryAdapter struct {
+	bus     *MemoryBus
+	handler func(msg AdapterMessage)
+}
+
+// NewMemoryBus Create a new in-process bus
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		subscribers: make(map[*memoryAdapter]string),
+	}
+}
+
+// Adapter Create a new adapter connected to the bus,
+// every hub needs its own adapter
+func (b *MemoryBus) Adapter() Adapter {
+	return &memoryAdapter{
+		bus: b,
+	}
+}
+
+func (a *memoryAdapter) Publish(_ context.Context, msg AdapterMessage) error {
+	a.bus.RLock()
+	handlers := make([]func(msg AdapterMessage), 0, len(a.bus.subscribers))
+	for subscriber, node := range a.bus.subscribers {
+		if msg.To == "" || msg.To == node {
+			handlers = append(handlers, subscriber.handler)
+		}
+	}
+	a.bus.RUnlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+	return nil
+}
+
+func (a *memoryAdapter) Subscribe(node string, handler func(msg AdapterMessage)) error {
+	a.bus.Lock()
+	a.handler = handler
+	a.bus.subscribers[a] = node
+	a.bus.Unlock()
+	return nil
+}
+
+func (a *memoryAdapter) Close() error {
+	a.bus.Lock()
+	delete(a.bus.subscribers, a)
+	a.bus.Unlock()
+	return nil
+}
@@ -0,0 +1,125 @@
+package ikisocket
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// Create two hubs connected by a memory bus,
+// each one with a mocked connection
+func createClusterTest(t *testing.T) (*Hub, *WebsocketMock, *Hub, *WebsocketMock) {
+	bus := NewMemoryBus()
+
+	nodes := make([]*Hub, 2)
+	conns := make([]*WebsocketMock, 2)
+	for i := range nodes {
+		nodes[i] = NewHub()
+		require.Nil(t, nodes[i].UseAdapter(bus.Adapter()))
+
+		conns[i] = new(WebsocketMock)
+		conns[i].UUID = nodes[i].NodeID() + "-conn"
+		conns[i].On("Emit", mock.Anything).Return(nil)
+		conns[i].On("IsAlive").Return(true)
+		nodes[i].pool.set(conns[i])
+	}
+	require.NotEqual(t, nodes[0].NodeID(), nodes[1].NodeID())
+
+	return nodes[0], conns[0], nodes[1], conns[1]
+}
+
+func TestAdapterBroadcast(t *testing.T) {
+	a, connA, b, connB := createClusterTest(t)
+
+	connA.wg.Add(1)
+	connB.wg.Add(1)
+	a.Broadcast([]byte("test"))
+	connA.wg.Wait()
+	connB.wg.Wait()
+
+	// no echo of the own publication
+	connA.AssertNumberOfCalls(t, "Emit", 1)
+	connB.AssertNumberOfCalls(t, "Emit", 1)
+
+	connA.wg.Add(1)
+	connB.wg.Add(1)
+	b.Broadcast([]byte("test"))
+	connA.wg.Wait()
+	connB.wg.Wait()
+
+	connA.AssertNumberOfCalls(t, "Emit", 2)
+	connB.AssertNumberOfCalls(t, "Emit", 2)
+}
+
+func TestAdapterEmitTo(t *testing.T) {
+	a, connA, _, connB := createClusterTest(t)
+
+	connB.wg.Add(1)
+	require.Nil(t, a.EmitTo(connB.UUID, []byte("test")))
+	connB.wg.Wait()
+
+	connA.AssertNumberOfCalls(t, "Emit", 0)
+	connB.AssertNumberOfCalls(t, "Emit", 1)
+
+	// without adapter the remote uuid is unknown
+	require.Equal(t, ErrorInvalidConnection, NewHub().EmitTo(connB.UUID, []byte("test")))
+}
+
+func TestAdapterEmitToRoom(t *testing.T) {
+	a, connA, b, connB := createClusterTest(t)
+
+	a.rooms.join("lobby", connA.UUID)
+	b.rooms.join("lobby", connB.UUID)
+
+	connB.wg.Add(1)
+	a.EmitToRoom("lobby", []byte("test"), connA.UUID)
+	connB.wg.Wait()
+
+	connA.AssertNumberOfCalls(t, "Emit", 0)
+	connB.AssertNumberOfCalls(t, "Emit", 1)
+}
+
+func TestAdapterFire(t *testing.T) {
+	a, _, b, _ := createClusterTest(t)
+
+	kwsA := createWS(withHub(a))
+
+	kwsB := createWS(withHub(b))
+
+	h := new(HandlerMock)
+	h.On("OnCustomEvent", mock.Anything).Return(nil)
+	a.On("clusterevent", h.OnCustomEvent)
+	b.On("clusterevent", h.OnCustomEvent)
+
+	// the mocked connections are not listening
+	a.pool.delete(a.NodeID() + "-conn")
+	b.pool.delete(b.NodeID() + "-conn")
+
+	h.wg.Add(2)
+	a.Fire("clusterevent", []byte("test"))
+	h.wg.Wait()
+
+	h.AssertNumberOfCalls(t, "OnCustomEvent", 2)
+	require.Equal(t, kwsA.UUID, h.Calls[0].Arguments.Get(0).(*EventPayload).SocketUUID)
+	require.Equal(t, kwsB.UUID, h.Calls[1].Arguments.Get(0).(*EventPayload).SocketUUID)
+}
+
+func TestMemoryBusClose(t *testing.T) {
+	bus := NewMemoryBus()
+
+	received := 0
+	adapter := bus.Adapter()
+	require.Nil(t, adapter.Subscribe("node", func(msg AdapterMessage) {
+		received++
+	}))
+
+	require.Nil(t, bus.Adapter().Publish(context.Background(), AdapterMessage{Kind: AdapterBroadcast}))
+	require.Nil(t, bus.Adapter().Publish(context.Background(), AdapterMessage{Kind: AdapterBroadcast, To: "other"}))
+	require.Equal(t, 1, received)
+
+	require.Nil(t, adapter.Close())
+	require.Nil(t, bus.Adapter().Publish(context.Background(), AdapterMessage{Kind: AdapterBroadcast}))
+	require.Equal(t, 1, received)
+}
@@ -0,0 +1,87 @@
+package ikisocket
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Key of the fiber Locals passing the identity to the connection
+const identityLocal = "ikisocket.identity"
+
+// Identity Client identified by Config.Authenticate
+type Identity struct {
+	// UserID Id of the user, applied to the connection with SetUserID
+	UserID string
+	// Claims Verified claims of the token, if any. The strings, the maps
+	// and the lists are copied, other values must not reference the request.
+	Claims map[string]interface{}
+}
+
+// Run the authentication of the request, the identity is kept
+// in the Locals for the connection
+func authenticate(c *fiber.Ctx, fn func(c *fiber.Ctx) (Identity, error)) error {
+	identity, err := fn(c)
+	if err != nil {
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			return fiberErr
+		}
+		return fiber.ErrUnauthorized
+	}
+
+	// the values of the request are reused by fasthttp after the upgrade
+	identity.UserID = utils.CopyString(identity.UserID)
+	if identity.Claims != nil {
+		identity.Claims = copyClaim(identity.Claims).(map[string]interface{})
+	}
+	c.Locals(identityLocal, identity)
+	return nil
+}
+
+// Copy the strings of the claim, in the nested maps and lists too
+func copyClaim(claim interface{}) interface{} {
+	switch v := claim.(type) {
+	case string:
+		return utils.CopyString(v)
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			copied[utils.CopyString(key)] = copyClaim(value)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, value := range v {
+			copied[i] = copyClaim(value)
+		}
+		return copied
+	case []string:
+		copied := make([]string, len(v))
+		for i, value := range v {
+			copied[i] = utils.CopyString(value)
+		}
+		return copied
+	}
+	return claim
+}
+
+// Identity The client identified by Config.Authenticate,
+// empty when the endpoint has no authentication
+func (kws *Websocket) Identity() Identity {
+	return kws.identity
+}
+
+// The user the client is identified as, by Config.Authenticate or by
+// Config.UserIDLocal. Returns false if the endpoint identifies no client.
+func (kws *Websocket) identifiedUser() (string, bool) {
+	if kws.identity.UserID != "" {
+		return kws.identity.UserID, true
+	}
+	if kws.config.UserIDLocal != "" {
+		userID, _ := kws.Locals(kws.config.UserIDLocal).(string)
+		return userID, true
+	}
+	return "", kws.config.Authenticate != nil
+}
@@ -0,0 +1,80 @@
+package ikisocket
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// Dial the server, returns the status of a rejected upgrade
+func dialAuth(t *testing.T, ln *fasthttputil.InmemoryListener, query string) (*websocket.Conn, int) {
+	dialer := &websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	conn, resp, err := dialer.Dial("ws://"+ln.Addr().String()+"/"+query, nil)
+	if err != nil {
+		require.NotNil(t, resp)
+		return nil, resp.StatusCode
+	}
+	return conn, http.StatusSwitchingProtocols
+}
+
+func TestAuthenticate(t *testing.T) {
+	h := NewHub()
+
+	connected := make(chan *Websocket, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		connected <- kws
+	}, Config{
+		Authenticate: func(c *fiber.Ctx) (Identity, error) {
+			switch c.Query("user") {
+			case "":
+				return Identity{}, errors.New("anonymous")
+			case "banned":
+				return Identity{}, fiber.ErrForbidden
+			}
+			return Identity{
+				UserID: c.Query("user"),
+				Claims: map[string]interface{}{
+					"role":  c.Query("role"),
+					"teams": []interface{}{c.Query("team")},
+				},
+			}, nil
+		},
+	}))
+	defer shutdown()
+
+	_, status := dialAuth(t, ln, "")
+	require.Equal(t, fiber.StatusUnauthorized, status)
+
+	_, status = dialAuth(t, ln, "?user=banned")
+	require.Equal(t, fiber.StatusForbidden, status)
+
+	conn, status := dialAuth(t, ln, "?user=alice&role=admin&team=blue")
+	require.Equal(t, http.StatusSwitchingProtocols, status)
+
+	kws := <-connected
+	require.Equal(t, "alice", kws.Identity().UserID)
+	require.Equal(t, "alice", kws.UserID())
+	require.Equal(t, []string{kws.UUID}, h.pool.byUser("alice"))
+
+	// the request of a closed connection is reused by the next ones
+	_ = conn.Close()
+	for i := 0; i < 10; i++ {
+		other, status := dialAuth(t, ln, "?user=bobby&role=guest&team=gray")
+		require.Equal(t, http.StatusSwitchingProtocols, status)
+		<-connected
+		_ = other.Close()
+	}
+	require.Equal(t, "alice", kws.Identity().UserID)
+	require.Equal(t, "admin", kws.Identity().Claims["role"])
+	require.Equal(t, []interface{}{"blue"}, kws.Identity().Claims["teams"])
+}
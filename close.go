@@ -0,0 +1,141 @@
+package ikisocket
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	// the connection returns the errors of fasthttp/websocket, contrib/websocket
+	// does not export CloseError and its ErrCloseSent is a different value
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+)
+
+// CloseCode Status code of a close frame.
+// The close codes are defined in RFC 6455, section 11.7.
+type CloseCode int
+
+const (
+	// CloseNormalClosure The purpose of the connection has been fulfilled
+	CloseNormalClosure CloseCode = 1000
+	// CloseGoingAway The server is going down or the client navigated away
+	CloseGoingAway CloseCode = 1001
+	// CloseProtocolError The peer violated the protocol
+	CloseProtocolError CloseCode = 1002
+	// CloseUnsupportedData The peer sent a type of data that cannot be accepted
+	CloseUnsupportedData CloseCode = 1003
+	// CloseNoStatusReceived The close frame had no status code, never sent
+	CloseNoStatusReceived CloseCode = 1005
+	// CloseAbnormalClosure The connection was lost without a close frame, never sent
+	CloseAbnormalClosure CloseCode = 1006
+	// CloseInvalidFramePayloadData The message data was not consistent with its type
+	CloseInvalidFramePayloadData CloseCode = 1007
+	// ClosePolicyViolation The message violates a policy of the endpoint
+	ClosePolicyViolation CloseCode = 1008
+	// CloseMessageTooBig The message is too big to be processed
+	CloseMessageTooBig CloseCode = 1009
+	// CloseMandatoryExtension The server did not negotiate a required extension
+	CloseMandatoryExtension CloseCode = 1010
+	// CloseInternalServerErr The server encountered an unexpected condition
+	CloseInternalServerErr CloseCode = 1011
+	// CloseServiceRestart The server is restarting
+	CloseServiceRestart CloseCode = 1012
+	// CloseTryAgainLater The server is overloaded
+	CloseTryAgainLater CloseCode = 1013
+	// CloseTLSHandshake The TLS handshake failed, never sent
+	CloseTLSHandshake CloseCode = 1015
+)
+
+// Max length of the reason of a close frame, the control frames
+// payload is limited to 125 bytes and the code takes 2 of them
+const maxCloseReason = 123
+
+var (
+	// ErrorInvalidCloseCode The close code cannot be sent in a close frame
+	// or the reason is longer than 123 bytes
+	ErrorInvalidCloseCode = errors.New("close code cannot be sent or reason is longer than 123 bytes")
+	// ErrorCloseTimeout The client did not answer to the close frame in time
+	ErrorCloseTimeout = errors.New("close handshake timeout, no close frame received from the client")
+)
+
+// Check that the code can be sent in a close frame
+func validCloseCode(code CloseCode) bool {
+	switch {
+	case code >= CloseNormalClosure && code <= CloseUnsupportedData:
+		return true
+	case code >= CloseInvalidFramePayloadData && code <= CloseTryAgainLater:
+		return true
+	// codes reserved to libraries, frameworks and applications
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// CloseWithCode Start the close handshake of the connection.
+// The close frame is sent after the messages already queued, then the
+// connection waits for the close frame of the client up to CloseTimeout and
+// is disconnected. EventClose is fired once the close frame is queued.
+func (kws *Websocket) CloseWithCode(code CloseCode, reason string) error {
+	return kws.closeWithCode(context.Background(), code, reason)
+}
+
+// Start the close handshake, stop waiting for the queue when the context expires
+func (kws *Websocket) closeWithCode(ctx context.Context, code CloseCode, reason string) error {
+	if !validCloseCode(code) || len(reason) > maxCloseReason {
+		return ErrorInvalidCloseCode
+	}
+
+	kws.mu.Lock()
+	if !kws.isAlive || kws.closing {
+		kws.mu.Unlock()
+		return ErrorInvalidConnection
+	}
+	kws.closing = true
+	kws.mu.Unlock()
+
+	select {
+	case kws.queue <- message{
+		mType: CloseMessage,
+		data:  websocket.FormatCloseMessage(int(code), reason),
+	}:
+	case <-kws.done:
+		return ErrorInvalidConnection
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	kws.fireEvent(EventClose, nil, nil)
+
+	// the close frame of the client is received by the read loop,
+	// the wait must not block it when called from a listener
+	go kws.awaitClose()
+
+	return nil
+}
+
+// Disconnect the connection if the client does not answer to the close frame
+func (kws *Websocket) awaitClose() {
+	timer := time.NewTimer(kws.config.CloseTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-kws.done:
+	case <-timer.C:
+		kws.disconnected(ErrorCloseTimeout)
+	}
+}
+
+// Fill the close code and reason sent by the client
+func (payload *EventPayload) setCloseError(err error) {
+	var closeErr *fasthttpws.CloseError
+	if errors.As(err, &closeErr) {
+		payload.CloseCode = CloseCode(closeErr.Code)
+		payload.CloseReason = closeErr.Text
+	}
+}
+
+// Returns true if the message was written after the close frame
+func isCloseSent(err error) bool {
+	return errors.Is(err, fasthttpws.ErrCloseSent)
+}
@@ -0,0 +1,117 @@
+package ikisocket
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseWithCode(t *testing.T) {
+	h := NewHub()
+
+	closed := make(chan struct{}, 1)
+	h.On(EventClose, func(payload *EventPayload) {
+		closed <- struct{}{}
+	})
+	disconnects := make(chan EventPayload, 1)
+	h.On(EventDisconnect, func(payload *EventPayload) {
+		disconnects <- *payload
+	})
+
+	connected := make(chan *Websocket, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		connected <- kws
+	}))
+	defer shutdown()
+
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+	kws := <-connected
+
+	kws.Emit([]byte("pending"))
+	require.Nil(t, kws.CloseWithCode(ClosePolicyViolation, "bye"))
+	require.Equal(t, ErrorInvalidConnection, kws.CloseWithCode(ClosePolicyViolation, "bye"))
+	<-closed
+
+	// the queued message comes before the close frame
+	_, msg, err := conn.ReadMessage()
+	require.Nil(t, err)
+	require.Equal(t, "pending", string(msg))
+
+	// reading the close frame answers to it
+	_, _, err = conn.ReadMessage()
+	closeErr, ok := err.(*websocket.CloseError)
+	require.True(t, ok)
+	require.Equal(t, int(ClosePolicyViolation), closeErr.Code)
+	require.Equal(t, "bye", closeErr.Text)
+
+	payload := <-disconnects
+	require.Equal(t, ClosePolicyViolation, payload.CloseCode)
+	require.False(t, kws.IsAlive())
+	require.Empty(t, h.pool.all())
+}
+
+func TestCloseFromClient(t *testing.T) {
+	h := NewHub()
+
+	disconnects := make(chan EventPayload, 1)
+	h.On(EventDisconnect, func(payload *EventPayload) {
+		disconnects <- *payload
+	})
+
+	connected := make(chan struct{}, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		connected <- struct{}{}
+	}))
+	defer shutdown()
+
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+	<-connected
+
+	require.Nil(t, conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4001, "custom")))
+
+	payload := <-disconnects
+	require.Equal(t, CloseCode(4001), payload.CloseCode)
+	require.Equal(t, "custom", payload.CloseReason)
+}
+
+func TestCloseTimeout(t *testing.T) {
+	h := NewHub(Config{CloseTimeout: 50 * time.Millisecond})
+
+	disconnects := make(chan error, 1)
+	h.On(EventDisconnect, func(payload *EventPayload) {
+		disconnects <- payload.Error
+	})
+
+	connected := make(chan *Websocket, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		connected <- kws
+	}))
+	defer shutdown()
+
+	// the client never reads, the close frame is not answered
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+	kws := <-connected
+
+	kws.Close()
+	require.Equal(t, ErrorCloseTimeout, <-disconnects)
+	require.Empty(t, h.pool.all())
+}
+
+func TestCloseWithCodeInvalid(t *testing.T) {
+	kws := createWS()
+	require.Equal(t, ErrorInvalidCloseCode, kws.CloseWithCode(CloseAbnormalClosure, ""))
+	require.Equal(t, ErrorInvalidCloseCode, kws.CloseWithCode(999, ""))
+	require.Equal(t, ErrorInvalidCloseCode, kws.CloseWithCode(CloseNormalClosure, strings.Repeat("a", 124)))
+}
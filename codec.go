@@ -0,0 +1,96 @@
+package ikisocket
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec Encodes the values emitted with EmitValue
+// and decodes the payloads with EventPayload.Decode
+type Codec interface {
+	// Marshal Encode the value
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal Decode the data into the value
+	Unmarshal(data []byte, v interface{}) error
+	// MessageType The message type used to send
+	// the encoded values, TextMessage or BinaryMessage
+	MessageType() int
+}
+
+var (
+	// JSONCodec Encodes the values as JSON text messages
+	JSONCodec Codec = jsonCodec{}
+	// MessagePackCodec Encodes the values as MessagePack binary messages
+	MessagePackCodec Codec = msgpackCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) MessageType() int {
+	return TextMessage
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+func (msgpackCodec) MessageType() int {
+	return BinaryMessage
+}
+
+// The codec of the connection, falls back to JSON
+func (kws *Websocket) codec() Codec {
+	if kws.config.Codec == nil {
+		return JSONCodec
+	}
+	return kws.config.Codec
+}
+
+// EmitValue Encode the value with the codec of the connection
+// and emit it with the message type of the codec
+func (kws *Websocket) EmitValue(v interface{}) error {
+	return kws.emitCodec(kws.codec(), v)
+}
+
+// EmitJSON Encode the value as JSON and emit it as text message
+func (kws *Websocket) EmitJSON(v interface{}) error {
+	return kws.emitCodec(JSONCodec, v)
+}
+
+func (kws *Websocket) emitCodec(codec Codec, v interface{}) error {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	kws.Emit(data, codec.MessageType())
+	return nil
+}
+
+// Decode Decode the payload data into the value,
+// the data of the envelopes is always decoded as JSON
+func (ep *EventPayload) Decode(v interface{}) error {
+	if ep.raw != nil {
+		// a middleware may have replaced the data of the envelope
+		if !bytes.Equal(ep.Data, ep.unquoted) {
+			return JSONCodec.Unmarshal(ep.Data, v)
+		}
+		return JSONCodec.Unmarshal(ep.raw, v)
+	}
+	return ep.Kws.codec().Unmarshal(ep.Data, v)
+}
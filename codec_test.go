@@ -0,0 +1,99 @@
+package ikisocket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type codecTestMessage struct {
+	Text  string `json:"text" msgpack:"text"`
+	Count int    `json:"count" msgpack:"count"`
+}
+
+func TestCodecEmitValue(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, MessagePackCodec} {
+		kws := createWS(withQueue(2))
+		kws.config.Codec = codec
+
+		require.Nil(t, kws.EmitValue(codecTestMessage{Text: "hello", Count: 2}))
+
+		msg := <-kws.queue
+		require.Equal(t, codec.MessageType(), msg.mType)
+
+		var decoded codecTestMessage
+		require.Nil(t, codec.Unmarshal(msg.data, &decoded))
+		require.Equal(t, codecTestMessage{Text: "hello", Count: 2}, decoded)
+
+		require.Nil(t, kws.EmitJSON(codecTestMessage{Text: "json"}))
+		msg = <-kws.queue
+		require.Equal(t, TextMessage, msg.mType)
+		require.JSONEq(t, `{"text":"json","count":0}`, string(msg.data))
+	}
+
+	kws := createWS()
+	require.NotNil(t, kws.EmitValue(make(chan int)))
+}
+
+func TestEventPayload_Decode(t *testing.T) {
+	h := NewHub(Config{Codec: MessagePackCodec})
+
+	received := make(chan codecTestMessage, 1)
+	h.On(EventMessage, func(payload *EventPayload) {
+		var decoded codecTestMessage
+		require.Nil(t, payload.Decode(&decoded))
+		received <- decoded
+	})
+
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {}))
+	defer shutdown()
+
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	data, err := msgpack.Marshal(codecTestMessage{Text: "hello", Count: 3})
+	require.Nil(t, err)
+	require.Nil(t, conn.WriteMessage(BinaryMessage, data))
+	require.Equal(t, codecTestMessage{Text: "hello", Count: 3}, <-received)
+
+	// the envelope data is always JSON
+	kws := createWS()
+	kws.config.Codec = MessagePackCodec
+	kws.config.Envelope = true
+	payload := kws.decodeMessage(TextMessage, []byte(`{"event":"x","data":{"text":"env","count":1}}`))
+	payload.Kws = kws
+
+	var decoded codecTestMessage
+	require.Nil(t, payload.Decode(&decoded))
+	require.Equal(t, codecTestMessage{Text: "env", Count: 1}, decoded)
+
+	// string data is unquoted in Data only
+	payload = kws.decodeMessage(TextMessage, []byte(`{"event":"x","data":"hello"}`))
+	payload.Kws = kws
+	require.Equal(t, "hello", string(payload.Data))
+
+	var text string
+	require.Nil(t, payload.Decode(&text))
+	require.Equal(t, "hello", text)
+
+	// the data replaced by a middleware is decoded
+	h.Use(func(next Handler) Handler {
+		return func(payload *EventPayload) error {
+			payload.Data = []byte(`{"text":"changed","count":2}`)
+			return next(payload)
+		}
+	})
+	kws = createWS(withHub(h))
+	kws.config.Envelope = true
+	changed := make(chan codecTestMessage, 1)
+	kws.On("x", func(payload *EventPayload) {
+		var decoded codecTestMessage
+		require.Nil(t, payload.Decode(&decoded))
+		changed <- decoded
+	})
+	kws.dispatch(kws.decodeMessage(TextMessage, []byte(`{"event":"x","data":{"text":"env","count":1}}`)))
+	require.Equal(t, codecTestMessage{Text: "changed", Count: 2}, <-changed)
+}
@@ -0,0 +1,331 @@
+package ikisocket
+
+import (
+	"errors"
+	"runtime"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Config defines the config of a hub or of a single endpoint
+type Config struct {
+	// QueueSize Size of the queue of outgoing messages of every connection
+	//
+	// Optional. Default: 100
+	QueueSize int
+
+	// OverflowPolicy Behaviour of the emits when the queue is full
+	//
+	// Optional. Default: OverflowBlock
+	OverflowPolicy OverflowPolicy
+
+	// PingInterval Interval between the ping control messages sent to the client
+	//
+	// Optional. Default: PongTimeout, or half PongDeadline when longer
+	PingInterval time.Duration
+
+	// PongDeadline Max time to wait for a pong, or any other message,
+	// from the client before disconnecting it with ErrorHeartbeatTimeout.
+	// Must be longer than PingInterval.
+	//
+	// Optional. Default: 10 * time.Second, or twice PingInterval when longer
+	PongDeadline time.Duration
+
+	// ReadLimit Max size in bytes of a message read from the client,
+	// zero means no limit
+	//
+	// Optional. Default: 0
+	ReadLimit int64
+
+	// WriteTimeout Deadline of a single write on the connection,
+	// zero means no deadline
+	//
+	// Optional. Default: 0
+	WriteTimeout time.Duration
+
+	// CloseTimeout Max time to wait for the close frame of the client
+	// after CloseWithCode before disconnecting it with ErrorCloseTimeout
+	//
+	// Optional. Default: 5 * time.Second
+	CloseTimeout time.Duration
+
+	// MaxSendRetry Max retries of a message if there are socket issues
+	//
+	// Optional. Default: MaxSendRetry
+	MaxSendRetry int
+
+	// RetrySendTimeout Pause before retrying to send a message
+	//
+	// Optional. Default: RetrySendTimeout
+	RetrySendTimeout time.Duration
+
+	// Envelope Decode the inbound JSON text messages shaped as
+	// {"event": "name", "data": <any json>, "id": 1} and fire the named
+	// event, or EventMessage when the event is omitted. The listeners
+	// receive the data and can answer with EventPayload.Ack.
+	// Raw messages are still fired as EventMessage.
+	//
+	// Optional. Default: false
+	Envelope bool
+
+	// Codec Encodes the values of EmitValue and decodes the
+	// payloads of EventPayload.Decode, JSONCodec and MessagePackCodec
+	// are provided
+	//
+	// Optional. Default: JSONCodec
+	Codec Codec
+
+	// UUIDGenerator Generates the unique id of every connection
+	//
+	// Optional. Default: random UUID v4
+	UUIDGenerator func() string
+
+	// DisconnectOnPanic Disconnect the connection whose event callback
+	// panicked, the panic is always recovered and fired as EventError
+	// with a *PanicError
+	//
+	// Optional. Default: false
+	DisconnectOnPanic bool
+
+	// Dispatch Where the middlewares and the listeners of the events are
+	// called, see DispatchSync, DispatchOrdered and DispatchPool for the
+	// ordering guarantees of every mode
+	//
+	// Optional. Default: DispatchSync
+	Dispatch DispatchMode
+
+	// DispatchWorkers Number of go routines of the pool shared by the
+	// connections of an endpoint, used by DispatchPool
+	//
+	// Optional. Default: runtime.NumCPU()
+	DispatchWorkers int
+
+	// DispatchQueueSize Size of the queue of the events waiting for
+	// a worker of the pool, used by DispatchPool, or for the go routine
+	// of the connection, used by DispatchOrdered
+	//
+	// Optional. Default: 1024
+	DispatchQueueSize int
+
+	// SessionTTL Grace period of the session of a disconnected client,
+	// zero disables the sessions. On connection the client receives the
+	// text message {"event": "session", "data": {"token": "...", "seq": 0}}
+	// and every following text or binary message has the next sequence
+	// number. Reconnecting within the grace period with the query
+	// parameters SessionTokenQuery and SessionSeqQuery, set to the sequence
+	// number of the last message received, restores the uuid, attributes
+	// and rooms of the connection and sends again the missed messages after
+	// a new session message. Its seq is greater than the one of the client
+	// when some messages were lost. The messages emitted to the connection
+	// during the grace period are kept. The sessions are local to the node
+	// and are not kept when the connection is closed with a close frame
+	// by the server or by the client with CloseNormalClosure, or when
+	// another connection takes their uuid with SetUUID. A resumed session
+	// waits up to CloseTimeout for the queue of the previous connection.
+	// With Authenticate or UserIDLocal a session is resumed only by the
+	// same user.
+	//
+	// Optional. Default: 0
+	SessionTTL time.Duration
+
+	// SessionOutboxSize Number of messages kept by a session for the replay
+	//
+	// Optional. Default: 100
+	SessionOutboxSize int
+
+	// RetransmitInterval Pause before sending again a message of
+	// EmitReliable not acknowledged by the client
+	//
+	// Optional. Default: 5 * time.Second
+	RetransmitInterval time.Duration
+
+	// MaxRetransmits Max times a message of EmitReliable is sent again
+	// before firing EventError with ErrorDeliveryFailed
+	//
+	// Optional. Default: 5
+	MaxRetransmits int
+
+	// RetransmitBufferSize Max messages of EmitReliable waiting for the
+	// acknowledgement of the client
+	//
+	// Optional. Default: 100
+	RetransmitBufferSize int
+
+	// DedupeWindow Number of the last inbound envelope ids remembered,
+	// a message with an id already received is not dispatched and the
+	// acknowledgement sent with EventPayload.Ack is sent again.
+	// Zero disables the check. Requires Envelope.
+	//
+	// Optional. Default: 0
+	DedupeWindow int
+
+	// UserIDLocal Key of the fiber Locals holding the user id of the
+	// connection as a string, set with SetUserID before the callback.
+	// Empty disables it.
+	//
+	// Optional. Default: ""
+	UserIDLocal string
+
+	// Authenticate Called before the upgrade to identify the client,
+	// an error rejects the request with the status of a *fiber.Error
+	// or with fiber.StatusUnauthorized. The identity is available with
+	// kws.Identity() and its UserID, when set, is applied with SetUserID.
+	// JWTHS256 and JWTRS256 verify a JWT bearer or query token.
+	//
+	// Optional. Default: nil
+	Authenticate func(c *fiber.Ctx) (Identity, error)
+}
+
+// OverflowPolicy Defines what happens to a message emitted
+// to a connection whose queue is full, every dropped message
+// fires EventError with ErrorQueueFull and the message as data
+type OverflowPolicy int
+
+const (
+	// OverflowBlock Wait for a free slot in the queue,
+	// a slow client blocks the emitting go routine
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest Drop the emitted message
+	OverflowDropNewest
+	// OverflowDropOldest Drop the oldest queued messages
+	// to make room for the emitted one
+	OverflowDropOldest
+	// OverflowDisconnect Disconnect the slow client,
+	// EventDisconnect is fired with ErrorQueueFull
+	OverflowDisconnect
+)
+
+var (
+	// ErrorInvalidQueueSize The queue size of the config is negative
+	ErrorInvalidQueueSize = errors.New("config QueueSize cannot be negative")
+	// ErrorInvalidTimeout One of the durations of the config is negative
+	ErrorInvalidTimeout = errors.New("config timeouts and intervals cannot be negative")
+	// ErrorInvalidPongDeadline The pong deadline is shorter than the ping interval
+	ErrorInvalidPongDeadline = errors.New("config PongDeadline must be longer than PingInterval")
+	// ErrorInvalidReadLimit The read limit of the config is negative
+	ErrorInvalidReadLimit = errors.New("config ReadLimit cannot be negative")
+	// ErrorInvalidSendRetry The max send retries of the config is negative
+	ErrorInvalidSendRetry = errors.New("config MaxSendRetry cannot be negative")
+	// ErrorInvalidOverflowPolicy The overflow policy of the config is unknown
+	ErrorInvalidOverflowPolicy = errors.New("config OverflowPolicy is not supported")
+	// ErrorInvalidOutboxSize The session outbox size of the config is negative
+	ErrorInvalidOutboxSize = errors.New("config SessionOutboxSize cannot be negative")
+	// ErrorInvalidReliable One of the reliable delivery sizes of the config is negative
+	ErrorInvalidReliable = errors.New("config MaxRetransmits, RetransmitBufferSize and DedupeWindow cannot be negative")
+	// ErrorInvalidDispatch The dispatch mode of the config is unknown
+	// or the size of its pool is negative
+	ErrorInvalidDispatch = errors.New("config Dispatch is not supported or DispatchWorkers/DispatchQueueSize are negative")
+)
+
+// Default PongDeadline, unless PingInterval is longer
+const defaultPongDeadline = 10 * time.Second
+
+// ConfigDefault is the default config
+var ConfigDefault = Config{
+	QueueSize:            100,
+	CloseTimeout:         5 * time.Second,
+	DispatchWorkers:      runtime.NumCPU(),
+	DispatchQueueSize:    1024,
+	SessionOutboxSize:    100,
+	RetransmitInterval:   5 * time.Second,
+	MaxRetransmits:       5,
+	RetransmitBufferSize: 100,
+}
+
+// Validate Check that the config values are usable
+func (cfg Config) Validate() error {
+	if cfg.QueueSize < 0 {
+		return ErrorInvalidQueueSize
+	}
+	if cfg.PingInterval < 0 || cfg.PongDeadline < 0 || cfg.WriteTimeout < 0 || cfg.RetrySendTimeout < 0 || cfg.CloseTimeout < 0 || cfg.SessionTTL < 0 || cfg.RetransmitInterval < 0 {
+		return ErrorInvalidTimeout
+	}
+	if cfg.PingInterval > 0 && cfg.PongDeadline > 0 && cfg.PongDeadline <= cfg.PingInterval {
+		return ErrorInvalidPongDeadline
+	}
+	if cfg.ReadLimit < 0 {
+		return ErrorInvalidReadLimit
+	}
+	if cfg.MaxSendRetry < 0 {
+		return ErrorInvalidSendRetry
+	}
+	if cfg.OverflowPolicy < OverflowBlock || cfg.OverflowPolicy > OverflowDisconnect {
+		return ErrorInvalidOverflowPolicy
+	}
+	if cfg.SessionOutboxSize < 0 {
+		return ErrorInvalidOutboxSize
+	}
+	if cfg.MaxRetransmits < 0 || cfg.RetransmitBufferSize < 0 || cfg.DedupeWindow < 0 {
+		return ErrorInvalidReliable
+	}
+	if cfg.Dispatch < DispatchSync || cfg.Dispatch > DispatchPool || cfg.DispatchWorkers < 0 || cfg.DispatchQueueSize < 0 {
+		return ErrorInvalidDispatch
+	}
+	return nil
+}
+
+// Helper function to set default values
+func configDefault(config ...Config) Config {
+	// Return default config if nothing provided
+	if len(config) < 1 {
+		config = append(config, ConfigDefault)
+	}
+
+	// Override default config
+	cfg := config[0]
+
+	// Set default values
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = ConfigDefault.QueueSize
+	}
+	if cfg.PingInterval == 0 {
+		cfg.PingInterval = PongTimeout
+		// always shorter than the deadline set by the user
+		if cfg.PongDeadline > 0 && cfg.PingInterval >= cfg.PongDeadline {
+			cfg.PingInterval = cfg.PongDeadline / 2
+		}
+	}
+	if cfg.PongDeadline == 0 {
+		// always longer than the resolved ping interval
+		cfg.PongDeadline = defaultPongDeadline
+		if cfg.PongDeadline <= cfg.PingInterval {
+			cfg.PongDeadline = 2 * cfg.PingInterval
+		}
+	}
+	if cfg.CloseTimeout == 0 {
+		cfg.CloseTimeout = ConfigDefault.CloseTimeout
+	}
+	if cfg.MaxSendRetry == 0 {
+		cfg.MaxSendRetry = MaxSendRetry
+	}
+	if cfg.RetrySendTimeout == 0 {
+		cfg.RetrySendTimeout = RetrySendTimeout
+	}
+	if cfg.Codec == nil {
+		cfg.Codec = JSONCodec
+	}
+	if cfg.UUIDGenerator == nil {
+		cfg.UUIDGenerator = ConfigDefault.UUIDGenerator
+	}
+	if cfg.DispatchWorkers == 0 {
+		cfg.DispatchWorkers = ConfigDefault.DispatchWorkers
+	}
+	if cfg.RetransmitInterval == 0 {
+		cfg.RetransmitInterval = ConfigDefault.RetransmitInterval
+	}
+	if cfg.MaxRetransmits == 0 {
+		cfg.MaxRetransmits = ConfigDefault.MaxRetransmits
+	}
+	if cfg.RetransmitBufferSize == 0 {
+		cfg.RetransmitBufferSize = ConfigDefault.RetransmitBufferSize
+	}
+	if cfg.SessionOutboxSize == 0 {
+		cfg.SessionOutboxSize = ConfigDefault.SessionOutboxSize
+	}
+	if cfg.DispatchQueueSize == 0 {
+		cfg.DispatchQueueSize = ConfigDefault.DispatchQueueSize
+	}
+
+	return cfg
+}
@@ -0,0 +1,75 @@
+package ikisocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigDefault(t *testing.T) {
+	cfg := configDefault()
+	require.Equal(t, 100, cfg.QueueSize)
+	require.Equal(t, PongTimeout, cfg.PingInterval)
+	require.Equal(t, 10*time.Second, cfg.PongDeadline)
+	require.Equal(t, MaxSendRetry, cfg.MaxSendRetry)
+	require.Equal(t, RetrySendTimeout, cfg.RetrySendTimeout)
+	require.Equal(t, 5*time.Second, cfg.CloseTimeout)
+	require.Nil(t, cfg.Validate())
+
+	cfg = configDefault(Config{
+		QueueSize:     10,
+		UUIDGenerator: func() string { return "fixed" },
+	})
+	require.Equal(t, 10, cfg.QueueSize)
+	require.Equal(t, "fixed", cfg.UUIDGenerator())
+
+	kws := createWS(withConfig(cfg))
+	require.Equal(t, "fixed", kws.createUUID())
+}
+
+func TestConfigDefaultHeartbeat(t *testing.T) {
+	// the deadline follows a long ping interval
+	cfg := configDefault(Config{PingInterval: 15 * time.Second})
+	require.Equal(t, 30*time.Second, cfg.PongDeadline)
+	require.Nil(t, cfg.Validate())
+	require.NotNil(t, NewHub(Config{PingInterval: 15 * time.Second}))
+
+	// and the ping interval a short deadline
+	cfg = configDefault(Config{PongDeadline: 500 * time.Millisecond})
+	require.Equal(t, 250*time.Millisecond, cfg.PingInterval)
+	require.Nil(t, cfg.Validate())
+
+	previous := PongTimeout
+	PongTimeout = 10 * time.Second
+	defer func() {
+		PongTimeout = previous
+	}()
+	cfg = configDefault()
+	require.Equal(t, 20*time.Second, cfg.PongDeadline)
+	require.Nil(t, cfg.Validate())
+}
+
+func TestConfigValidate(t *testing.T) {
+	require.Equal(t, ErrorInvalidQueueSize, Config{QueueSize: -1}.Validate())
+	require.Equal(t, ErrorInvalidTimeout, Config{WriteTimeout: -time.Second}.Validate())
+	require.Equal(t, ErrorInvalidTimeout, Config{CloseTimeout: -time.Second}.Validate())
+	require.Equal(t, ErrorInvalidReadLimit, Config{ReadLimit: -1}.Validate())
+	require.Equal(t, ErrorInvalidSendRetry, Config{MaxSendRetry: -1}.Validate())
+	require.Equal(t, ErrorInvalidOverflowPolicy, Config{OverflowPolicy: 10}.Validate())
+	require.Equal(t, ErrorInvalidOutboxSize, Config{SessionOutboxSize: -1}.Validate())
+	require.Equal(t, ErrorInvalidReliable, Config{DedupeWindow: -1}.Validate())
+	require.Equal(t, ErrorInvalidDispatch, Config{Dispatch: 10}.Validate())
+	require.Equal(t, ErrorInvalidDispatch, Config{DispatchWorkers: -1}.Validate())
+	require.Equal(t, ErrorInvalidPongDeadline, Config{
+		PingInterval: 5 * time.Second,
+		PongDeadline: time.Second,
+	}.Validate())
+
+	assertPanic(t, func() {
+		NewHub(Config{QueueSize: -1})
+	})
+	assertPanic(t, func() {
+		NewHub().New(func(kws *Websocket) {}, Config{ReadLimit: -1})
+	})
+}
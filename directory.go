@@ -0,0 +1,231 @@
+package ikisocket
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Directory Maps the connection uuids to the node owning them, so the
+// targeted emits of a cluster are routed only to that node. The entries
+// are leases refreshed by the owner, the ones of crashed nodes expire.
+type Directory interface {
+	// Register Claim, or refresh, the uuids for the node until the ttl expires
+	Register(ctx context.Context, node string, ttl time.Duration, uuids ...string) error
+	// Unregister Release the uuid, only if it is owned by the node
+	Unregister(ctx context.Context, node string, uuid string) error
+	// Lookup The node owning the uuid, empty if unknown or expired
+	Lookup(ctx context.Context, uuid string) (string, error)
+}
+
+// DirectoryTTL Default lease duration of the directory entries
+const DirectoryTTL = 30 * time.Second
+
+// UseDirectory Register the connections of the hub in the directory,
+// refreshing the leases every third of the ttl, and route the EmitTo
+// of the uuids not in the local pool to the owner node through the adapter.
+// The leases are refreshed only while the hub has connections, a nil
+// directory stops the routing and the renewal of the previous one.
+func (h *Hub) UseDirectory(directory Directory, ttl ...time.Duration) {
+	leaseTTL := DirectoryTTL
+	if len(ttl) > 0 && ttl[0] > 0 {
+		leaseTTL = ttl[0]
+	}
+
+	h.mu.Lock()
+	if h.stopDirectory != nil {
+		close(h.stopDirectory)
+		h.stopDirectory = nil
+	}
+	h.directory = directory
+	h.directoryTTL = leaseTTL
+	h.mu.Unlock()
+
+	if directory == nil {
+		return
+	}
+
+	// claim the connections already in the pool
+	if h.refreshDirectory(directory, leaseTTL) {
+		h.renewDirectory()
+	}
+}
+
+// Start the renewal of the leases unless it is running,
+// it stops by itself when the hub has no connection left
+func (h *Hub) renewDirectory() {
+	h.mu.Lock()
+	if h.directory == nil || h.stopDirectory != nil || h.closing {
+		h.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	h.stopDirectory = stop
+	directory, ttl := h.directory, h.directoryTTL
+	h.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !h.refreshDirectory(directory, ttl) && h.stopRenewal(stop) {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the renewal if the hub still has no connection, the connections
+// registered afterwards start a new one. Returns false if it continues.
+func (h *Hub) stopRenewal(stop chan struct{}) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.directoryUUIDs()) > 0 {
+		return false
+	}
+	if h.stopDirectory == stop {
+		h.stopDirectory = nil
+	}
+	return true
+}
+
+func (h *Hub) getDirectory() (Directory, time.Duration) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.directory, h.directoryTTL
+}
+
+// Refresh the leases of all the local connections,
+// returns false if there is no connection
+func (h *Hub) refreshDirectory(directory Directory, ttl time.Duration) bool {
+	uuids := h.directoryUUIDs()
+	if len(uuids) == 0 {
+		return false
+	}
+	_ = directory.Register(context.Background(), h.node, ttl, uuids...)
+	return true
+}
+
+// The uuids of the connections and of the parked sessions
+func (h *Hub) directoryUUIDs() []string {
+	uuids := make([]string, 0)
+	for wsUUID := range h.pool.all() {
+		uuids = append(uuids, wsUUID)
+	}
+	for _, s := range h.sessions.all() {
+		uuids = append(uuids, s.uuid)
+	}
+	return uuids
+}
+
+// The node owning the uuid, empty if there is no directory
+// or the uuid is unknown
+func (h *Hub) lookup(uuid string) (string, error) {
+	directory, _ := h.getDirectory()
+	if directory == nil {
+		return "", nil
+	}
+	return directory.Lookup(context.Background(), uuid)
+}
+
+// Claim the uuid of the connection in the directory
+func (kws *Websocket) register() {
+	h := kws.getHub()
+	directory, ttl := h.getDirectory()
+	if directory == nil {
+		return
+	}
+	if err := directory.Register(context.Background(), h.node, ttl, kws.GetUUID()); err != nil {
+		kws.fireEvent(EventError, []byte(kws.GetUUID()), err)
+	}
+	h.renewDirectory()
+}
+
+// Release a uuid of the connection in the directory
+func (kws *Websocket) unregister(uuid string) {
+	h := kws.getHub()
+	directory, _ := h.getDirectory()
+	if directory == nil {
+		return
+	}
+	if err := directory.Unregister(context.Background(), h.node, uuid); err != nil {
+		kws.fireEvent(EventError, []byte(uuid), err)
+	}
+}
+
+// UseDirectory Register the connections of the default hub in the directory
+func UseDirectory(directory Directory, ttl ...time.Duration) {
+	defaultHub.UseDirectory(directory, ttl...)
+}
+
+// MemoryDirectory In-process directory, shared by the hubs
+// of the same process as if they were different nodes
+type MemoryDirectory struct {
+	sync.Mutex
+	entries map[string]directoryEntry
+	// Time of the next removal of the expired entries
+	nextEvict time.Time
+}
+
+type directoryEntry struct {
+	node    string
+	expires time.Time
+}
+
+// NewMemoryDirectory Create a new in-process directory
+func NewMemoryDirectory() *MemoryDirectory {
+	return &MemoryDirectory{
+		entries: make(map[string]directoryEntry),
+	}
+}
+
+func (d *MemoryDirectory) Register(_ context.Context, node string, ttl time.Duration, uuids ...string) error {
+	d.Lock()
+	defer d.Unlock()
+	expires := time.Now().Add(ttl)
+	for _, wsUUID := range uuids {
+		d.entries[wsUUID] = directoryEntry{
+			node:    node,
+			expires: expires,
+		}
+	}
+	// the expired entries are removed at most once per ttl
+	if now := time.Now(); now.After(d.nextEvict) {
+		d.evict(now)
+		d.nextEvict = now.Add(ttl)
+	}
+	return nil
+}
+
+func (d *MemoryDirectory) Unregister(_ context.Context, node string, uuid string) error {
+	d.Lock()
+	defer d.Unlock()
+	if entry, ok := d.entries[uuid]; ok && entry.node == node {
+		delete(d.entries, uuid)
+	}
+	return nil
+}
+
+func (d *MemoryDirectory) Lookup(_ context.Context, uuid string) (string, error) {
+	d.Lock()
+	defer d.Unlock()
+	entry, ok := d.entries[uuid]
+	if !ok || time.Now().After(entry.expires) {
+		return "", nil
+	}
+	return entry.node, nil
+}
+
+// Remove the expired entries, must be called with the lock held
+func (d *MemoryDirectory) evict(now time.Time) {
+	for wsUUID, entry := range d.entries {
+		if now.After(entry.expires) {
+			delete(d.entries, wsUUID)
+		}
+	}
+}
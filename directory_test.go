@@ -0,0 +1,138 @@
+package ikisocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// Adapter counting the published messages
+type countingAdapter struct {
+	Adapter
+	published []AdapterMessage
+}
+
+func (a *countingAdapter) Publish(ctx context.Context, msg AdapterMessage) error {
+	a.published = append(a.published, msg)
+	return a.Adapter.Publish(ctx, msg)
+}
+
+func TestDirectoryRouting(t *testing.T) {
+	bus := NewMemoryBus()
+	directory := NewMemoryDirectory()
+
+	nodes := make([]*Hub, 3)
+	for i := range nodes {
+		nodes[i] = NewHub()
+	}
+	adapter := &countingAdapter{Adapter: bus.Adapter()}
+	require.Nil(t, nodes[0].UseAdapter(adapter))
+	require.Nil(t, nodes[1].UseAdapter(bus.Adapter()))
+	require.Nil(t, nodes[2].UseAdapter(bus.Adapter()))
+
+	remote := new(WebsocketMock)
+	remote.UUID = "remote-uuid"
+	remote.On("Emit", mock.Anything).Return(nil)
+	remote.On("IsAlive").Return(true)
+	nodes[1].pool.set(remote)
+
+	for _, node := range nodes {
+		node.UseDirectory(directory, time.Second)
+	}
+
+	owner, err := directory.Lookup(context.Background(), "remote-uuid")
+	require.Nil(t, err)
+	require.Equal(t, nodes[1].NodeID(), owner)
+
+	remote.wg.Add(1)
+	require.Nil(t, nodes[0].EmitTo("remote-uuid", []byte("test")))
+	remote.wg.Wait()
+
+	remote.AssertNumberOfCalls(t, "Emit", 1)
+	require.Len(t, adapter.published, 1)
+	require.Equal(t, nodes[1].NodeID(), adapter.published[0].To)
+
+	// unknown uuids are not published at all
+	require.Equal(t, ErrorInvalidConnection, nodes[0].EmitTo("unknown", []byte("test")))
+	require.Len(t, adapter.published, 1)
+}
+
+func TestDirectoryLeases(t *testing.T) {
+	directory := NewMemoryDirectory()
+	h := NewHub()
+
+	kws := createWS(withHub(h), withDone())
+
+	h.UseDirectory(directory, 60*time.Millisecond)
+
+	// refreshed while the connection is alive
+	time.Sleep(150 * time.Millisecond)
+	owner, _ := directory.Lookup(context.Background(), kws.UUID)
+	require.Equal(t, h.NodeID(), owner)
+
+	kws.SetUUID("renamed")
+	owner, _ = directory.Lookup(context.Background(), kws.UUID)
+	require.Equal(t, h.NodeID(), owner)
+
+	kws.disconnected(nil)
+	owner, _ = directory.Lookup(context.Background(), "renamed")
+	require.Equal(t, "", owner)
+
+	// a crashed node stops refreshing
+	require.Nil(t, directory.Register(context.Background(), "crashed", 20*time.Millisecond, "orphan"))
+	time.Sleep(40 * time.Millisecond)
+	owner, _ = directory.Lookup(context.Background(), "orphan")
+	require.Equal(t, "", owner)
+}
+
+func TestDirectoryRenewal(t *testing.T) {
+	directory := NewMemoryDirectory()
+	h := NewHub()
+
+	renewing := func() bool {
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		return h.stopDirectory != nil
+	}
+
+	// nothing to renew without connections
+	h.UseDirectory(directory, 30*time.Millisecond)
+	require.False(t, renewing())
+
+	kws := createWS(withHub(h), withDone())
+	kws.register()
+	require.True(t, renewing())
+
+	// stopped on the first tick without connections
+	kws.disconnected(nil)
+	require.Eventually(t, func() bool {
+		return !renewing()
+	}, time.Second, 5*time.Millisecond)
+
+	// a nil directory stops the renewal
+	h.pool.set(kws)
+	kws.register()
+	require.True(t, renewing())
+	h.UseDirectory(nil)
+	require.False(t, renewing())
+	node, err := h.lookup(kws.UUID)
+	require.Nil(t, err)
+	require.Empty(t, node)
+}
+
+func TestMemoryDirectoryEvict(t *testing.T) {
+	directory := NewMemoryDirectory()
+	ctx := context.Background()
+
+	require.Nil(t, directory.Register(ctx, "node", 20*time.Millisecond, "first"))
+	require.Nil(t, directory.Register(ctx, "node", 20*time.Millisecond, "second"))
+	require.Len(t, directory.entries, 2)
+
+	// the expired entries are removed by the next eviction
+	time.Sleep(30 * time.Millisecond)
+	require.Nil(t, directory.Register(ctx, "node", time.Second, "third"))
+	require.Len(t, directory.entries, 1)
+}
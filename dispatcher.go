@@ -0,0 +1,223 @@
+package ikisocket
+
+import (
+	"sync"
+)
+
+// DispatchMode Defines where the middlewares and the listeners
+// of the events of a connection are called
+type DispatchMode int
+
+const (
+	// DispatchSync Call the listeners in the go routine firing the event,
+	// the read loop of the connection waits for the listeners of every message
+	// and the events of a connection are handled in the order they are fired
+	DispatchSync DispatchMode = iota
+	// DispatchOrdered Call the listeners in a go routine of the connection,
+	// the events of a connection are handled one at a time in the order they
+	// are fired, different connections are handled concurrently. When
+	// DispatchQueueSize messages of the connection are waiting, its read
+	// loop waits for the listeners.
+	DispatchOrdered
+	// DispatchPool Call the listeners in a pool of DispatchWorkers go routines
+	// shared by the connections of the endpoint. The events have no ordering,
+	// even the ones of the same connection can be handled concurrently.
+	// When the queue of the pool is full the event is handled by the go
+	// routine firing it.
+	DispatchPool
+)
+
+// Bounded set of go routines shared by the connections of an endpoint,
+// started on the first submitted task and stopped by the hub Shutdown
+type workerPool struct {
+	once    sync.Once
+	mu      sync.RWMutex
+	stopped bool
+	workers int
+	tasks   chan func()
+}
+
+func newWorkerPool(workers int, queueSize int) *workerPool {
+	return &workerPool{
+		workers: workers,
+		tasks:   make(chan func(), queueSize),
+	}
+}
+
+func (p *workerPool) start() {
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			for task := range p.tasks {
+				task()
+			}
+		}()
+	}
+}
+
+// Queue the task, returns false if the queue is full or the pool stopped
+func (p *workerPool) submit(task func()) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		return false
+	}
+	p.once.Do(p.start)
+	select {
+	case p.tasks <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop the go routines once the queued tasks are completed
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	close(p.tasks)
+}
+
+// Schedules the dispatches of a connection according to its DispatchMode.
+// The zero value dispatches synchronously.
+type eventQueue struct {
+	mu   sync.Mutex
+	mode DispatchMode
+	// Pool of the endpoint, used by DispatchPool
+	workers *workerPool
+	// Pending tasks of DispatchOrdered, drained by a single go routine
+	tasks   []func()
+	running bool
+	// Max pending tasks of DispatchOrdered before the read loop waits
+	size int
+	room *sync.Cond
+	// Tasks of DispatchPool submitted and not completed
+	pending int
+	// Set once the connection is disconnected, the next tasks run inline
+	closed bool
+	// Called when the queue is closed and idle
+	release func()
+	drained chan struct{}
+}
+
+// Run the task according to the dispatch mode
+func (q *eventQueue) schedule(task func()) {
+	q.mu.Lock()
+	if q.closed || q.mode == DispatchSync {
+		q.mu.Unlock()
+		task()
+		return
+	}
+
+	if q.mode == DispatchOrdered {
+		q.tasks = append(q.tasks, task)
+		if !q.running {
+			q.running = true
+			go q.drain()
+		}
+		q.mu.Unlock()
+		return
+	}
+
+	q.pending++
+	q.mu.Unlock()
+
+	completed := func() {
+		task()
+		q.mu.Lock()
+		q.pending--
+		q.idle()
+		q.mu.Unlock()
+	}
+	if !q.workers.submit(completed) {
+		completed()
+	}
+}
+
+// Run the ordered tasks until the queue is empty
+func (q *eventQueue) drain() {
+	for {
+		q.mu.Lock()
+		if len(q.tasks) == 0 {
+			q.running = false
+			q.idle()
+			q.mu.Unlock()
+			return
+		}
+		task := q.tasks[0]
+		q.tasks[0] = nil
+		q.tasks = q.tasks[1:]
+		if q.room != nil {
+			q.room.Broadcast()
+		}
+		q.mu.Unlock()
+
+		task()
+	}
+}
+
+// Wait until the ordered queue has room for a task, the read loop
+// of a fast client waits for the listeners
+func (q *eventQueue) reserve() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.mode == DispatchOrdered && !q.closed && q.size > 0 && len(q.tasks) >= q.size {
+		if q.room == nil {
+			q.room = sync.NewCond(&q.mu)
+		}
+		q.room.Wait()
+	}
+}
+
+// Call the release function once the queue is closed and has no pending
+// tasks, must be called with the lock held
+func (q *eventQueue) idle() {
+	if !q.closed || q.running || q.pending > 0 || q.release == nil {
+		return
+	}
+
+	release := q.release
+	q.release = nil
+	// release may schedule new tasks, they run inline
+	q.mu.Unlock()
+	release()
+	q.mu.Lock()
+	close(q.drainedChan())
+}
+
+// Close the queue, release is called after the pending tasks
+func (q *eventQueue) close(release func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	if q.room != nil {
+		q.room.Broadcast()
+	}
+	q.release = release
+	if q.release == nil {
+		q.release = func() {}
+	}
+	q.idle()
+}
+
+// Wait until the queue is closed and the pending tasks are completed
+func (q *eventQueue) wait() {
+	q.mu.Lock()
+	drained := q.drainedChan()
+	q.mu.Unlock()
+	<-drained
+}
+
+// Must be called with the lock held
+func (q *eventQueue) drainedChan() chan struct{} {
+	if q.drained == nil {
+		q.drained = make(chan struct{})
+	}
+	return q.drained
+}
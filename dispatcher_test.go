@@ -0,0 +1,213 @@
+package ikisocket
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchOrdered(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h), withDone(), withDispatch(DispatchOrdered, nil))
+
+	release := make(chan struct{})
+	var received []string
+	var wg sync.WaitGroup
+	wg.Add(100)
+	h.On("event", func(payload *EventPayload) {
+		// the first event blocks the following ones, not the caller
+		<-release
+		received = append(received, string(payload.Data))
+		wg.Done()
+	})
+
+	expected := make([]string, 100)
+	for i := range expected {
+		expected[i] = strconv.Itoa(i)
+		kws.Fire("event", []byte(expected[i]))
+	}
+	close(release)
+	wg.Wait()
+	require.Equal(t, expected, received)
+}
+
+func TestDispatchPool(t *testing.T) {
+	h := NewHub()
+	workers := newWorkerPool(2, 10)
+	first := createWS(withHub(h), withDone(), withDispatch(DispatchPool, workers))
+	second := createWS(withHub(h), withDone(), withDispatch(DispatchPool, workers))
+
+	// both listeners must run at the same time to complete
+	var barrier sync.WaitGroup
+	barrier.Add(2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	h.On("event", func(payload *EventPayload) {
+		barrier.Done()
+		barrier.Wait()
+		wg.Done()
+	})
+
+	first.Fire("event", nil)
+	second.Fire("event", nil)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the events are not handled concurrently")
+	}
+}
+
+func TestDispatchPoolAttributes(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h), withDone(), withDispatch(DispatchPool, newWorkerPool(4, 10)))
+
+	// the listeners of the connection run at the same time, each one
+	// reads the attributes of its payload while the others set them
+	var barrier sync.WaitGroup
+	barrier.Add(4)
+	var wg sync.WaitGroup
+	wg.Add(4)
+	h.On("event", func(payload *EventPayload) {
+		defer wg.Done()
+		barrier.Done()
+		barrier.Wait()
+		for i := 0; i < 100; i++ {
+			_ = payload.SocketAttributes["key"]
+			payload.Kws.SetAttribute(string(payload.Data), i)
+		}
+	})
+
+	for i := 0; i < 4; i++ {
+		kws.Fire("event", []byte(strconv.Itoa(i)))
+	}
+	wg.Wait()
+	require.Equal(t, 99, kws.GetAttribute("3"))
+}
+
+func TestDispatchPoolFull(t *testing.T) {
+	workers := newWorkerPool(1, 1)
+	block := make(chan struct{})
+	started := make(chan struct{})
+	require.True(t, workers.submit(func() {
+		close(started)
+		<-block
+	}))
+	<-started
+	require.True(t, workers.submit(func() {}))
+
+	// the queue is full, the task runs in the calling go routine
+	q := eventQueue{mode: DispatchPool, workers: workers}
+	ran := false
+	q.schedule(func() {
+		ran = true
+	})
+	require.True(t, ran)
+	close(block)
+}
+
+func TestDispatchDisconnect(t *testing.T) {
+	for _, mode := range []DispatchMode{DispatchSync, DispatchOrdered, DispatchPool} {
+		h := NewHub()
+		kws := createWS(withHub(h), withDone(), withDispatch(mode, newWorkerPool(2, 10)))
+
+		var mu sync.Mutex
+		var calls []string
+		kws.On("event", func(payload *EventPayload) {
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			calls = append(calls, "event")
+			mu.Unlock()
+		})
+		kws.On(EventDisconnect, func(payload *EventPayload) {
+			mu.Lock()
+			calls = append(calls, EventDisconnect)
+			mu.Unlock()
+		})
+
+		kws.Fire("event", nil)
+		kws.disconnected(nil)
+		// wait returns after the pending events and the release of the listeners
+		kws.events.wait()
+
+		mu.Lock()
+		require.ElementsMatch(t, []string{"event", EventDisconnect}, calls, mode)
+		if mode != DispatchPool {
+			require.Equal(t, []string{"event", EventDisconnect}, calls, mode)
+		}
+		mu.Unlock()
+		require.Empty(t, kws.listeners.list, mode)
+
+		// after the disconnection the events are handled inline
+		called := false
+		h.On("late", func(payload *EventPayload) {
+			called = true
+		})
+		kws.Fire("late", nil)
+		require.True(t, called, mode)
+	}
+}
+
+func TestDispatchOrderedBackpressure(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h), withDone(), withDispatch(DispatchOrdered, nil))
+	kws.events.size = 2
+
+	release := make(chan struct{})
+	h.On("event", func(payload *EventPayload) {
+		<-release
+	})
+
+	// the first event is running, two are waiting
+	kws.events.reserve()
+	kws.Fire("event", nil)
+	require.Eventually(t, func() bool {
+		kws.events.mu.Lock()
+		defer kws.events.mu.Unlock()
+		return len(kws.events.tasks) == 0
+	}, time.Second, time.Millisecond)
+	kws.Fire("event", nil)
+	kws.Fire("event", nil)
+
+	reserved := make(chan struct{})
+	go func() {
+		kws.events.reserve()
+		close(reserved)
+	}()
+
+	select {
+	case <-reserved:
+		t.Fatal("the queue is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-reserved
+}
+
+func TestDispatchPoolShutdown(t *testing.T) {
+	h := NewHub()
+	h.New(func(kws *Websocket) {}, Config{Dispatch: DispatchPool})
+	require.Len(t, h.workers, 1)
+	workers := h.workers[0]
+
+	done := make(chan struct{})
+	require.True(t, workers.submit(func() {
+		close(done)
+	}))
+	<-done
+
+	require.Nil(t, h.Shutdown(context.Background()))
+	require.False(t, workers.submit(func() {}))
+	_, open := <-workers.tasks
+	require.False(t, open)
+}
@@ -0,0 +1,121 @@
+package ikisocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	// ErrorReservedEvent The client tried to fire one of the events
+	// reserved to the server, error data is the received message
+	ErrorReservedEvent = errors.New("the event is reserved and cannot be fired by the client")
+)
+
+// Events fired only by the server, the clients cannot trigger them
+var reservedEvents = map[string]bool{
+	EventPing:       true,
+	EventPong:       true,
+	EventDisconnect: true,
+	EventConnect:    true,
+	EventClose:      true,
+	EventError:      true,
+	EventJoin:       true,
+	EventLeave:      true,
+	EventResume:     true,
+	EventOnline:     true,
+	EventOffline:    true,
+}
+
+// Wire format of the JSON text messages used for the acknowledgements
+// and, when Config.Envelope is enabled, for every inbound text message:
+// the event name is dispatched to the listeners registered with On.
+//
+//	{"event": "name", "data": <any json>, "id": 1, "ack": 1, "seq": 1, "ack_seq": 1}
+//
+// Data that is not valid JSON is sent as a JSON string.
+type envelope struct {
+	// Name of the event
+	Event string `json:"event,omitempty"`
+	// Payload of the message
+	Data json.RawMessage `json:"data,omitempty"`
+	// Set when the sender expects an acknowledgement
+	ID uint64 `json:"id,omitempty"`
+	// Set when the message acknowledges the envelope with this id
+	Ack uint64 `json:"ack,omitempty"`
+	// Sequence number of a reliable message
+	Seq uint64 `json:"seq,omitempty"`
+	// Set when the message acknowledges the reliable message with this seq
+	AckSeq uint64 `json:"ack_seq,omitempty"`
+}
+
+// Encode the envelope, non JSON data is sent as a JSON string
+func (e envelope) encode(data []byte) []byte {
+	if len(data) > 0 {
+		if json.Valid(data) {
+			e.Data = data
+		} else {
+			e.Data, _ = json.Marshal(string(data))
+		}
+	}
+	ret, _ := json.Marshal(e)
+	return ret
+}
+
+// Data of the envelope, JSON strings are unquoted
+func (e envelope) data() []byte {
+	var str string
+	if len(e.Data) > 0 && e.Data[0] == '"' && json.Unmarshal(e.Data, &str) == nil {
+		return []byte(str)
+	}
+	return e.Data
+}
+
+// Decode a message as an envelope,
+// returns false if the message is not an envelope
+func decodeEnvelope(msg []byte) (envelope, bool) {
+	var e envelope
+	trimmed := bytes.TrimSpace(msg)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return e, false
+	}
+	if err := json.Unmarshal(trimmed, &e); err != nil {
+		return e, false
+	}
+	return e, e.Event != "" || len(e.Data) > 0 || e.ID != 0 || e.Ack != 0 || e.Seq != 0 || e.AckSeq != 0
+}
+
+// Build the payload of an inbound message, unwrapping the envelope
+// when enabled. Raw messages fall back to EventMessage.
+func (kws *Websocket) decodeMessage(mType int, msg []byte) EventPayload {
+	payload := EventPayload{
+		Name: EventMessage,
+		Data: msg,
+	}
+
+	if !kws.config.Envelope || mType != TextMessage {
+		return payload
+	}
+
+	e, ok := decodeEnvelope(msg)
+	if !ok {
+		return payload
+	}
+
+	if reservedEvents[e.Event] {
+		return EventPayload{
+			Name:  EventError,
+			Data:  msg,
+			Error: ErrorReservedEvent,
+		}
+	}
+
+	if e.Event != "" {
+		payload.Name = e.Event
+	}
+	payload.Data = e.data()
+	payload.ackID = e.ID
+	payload.raw = e.Data
+	payload.unquoted = payload.Data
+	return payload
+}
@@ -0,0 +1,68 @@
+package ikisocket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	kws := createWS()
+
+	// envelope disabled
+	payload := kws.decodeMessage(TextMessage, []byte(`{"event":"custom","data":"hello"}`))
+	require.Equal(t, EventMessage, payload.Name)
+	require.Equal(t, `{"event":"custom","data":"hello"}`, string(payload.Data))
+
+	kws.config.Envelope = true
+
+	payload = kws.decodeMessage(TextMessage, []byte(`{"event":"custom","data":"hello","id":3}`))
+	require.Equal(t, "custom", payload.Name)
+	require.Equal(t, "hello", string(payload.Data))
+	require.Equal(t, uint64(3), payload.ackID)
+
+	payload = kws.decodeMessage(TextMessage, []byte(`{"event":"custom","data":{"to":"someone"}}`))
+	require.Equal(t, "custom", payload.Name)
+	require.Equal(t, `{"to":"someone"}`, string(payload.Data))
+
+	payload = kws.decodeMessage(TextMessage, []byte(`{"data":[1,2]}`))
+	require.Equal(t, EventMessage, payload.Name)
+	require.Equal(t, `[1,2]`, string(payload.Data))
+
+	// raw messages fall back to EventMessage
+	for _, raw := range []string{"hello", `{"other":"field"}`, `{broken`} {
+		payload = kws.decodeMessage(TextMessage, []byte(raw))
+		require.Equal(t, EventMessage, payload.Name)
+		require.Equal(t, raw, string(payload.Data))
+	}
+
+	payload = kws.decodeMessage(BinaryMessage, []byte(`{"event":"custom"}`))
+	require.Equal(t, EventMessage, payload.Name)
+
+	payload = kws.decodeMessage(TextMessage, []byte(`{"event":"disconnect"}`))
+	require.Equal(t, EventError, payload.Name)
+	require.Equal(t, ErrorReservedEvent, payload.Error)
+}
+
+func TestEnvelopeEvent(t *testing.T) {
+	h := NewHub(Config{Envelope: true})
+
+	received := make(chan *EventPayload, 1)
+	h.On("chat", func(payload *EventPayload) {
+		received <- payload
+	})
+
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {}))
+	defer shutdown()
+
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	require.Nil(t, conn.WriteMessage(TextMessage, []byte(`{"event":"chat","data":"hello"}`)))
+
+	payload := <-received
+	require.Equal(t, "chat", payload.Name)
+	require.Equal(t, "hello", string(payload.Data))
+}
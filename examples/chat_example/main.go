@@ -4,9 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/antoniodipinto/ikisocket"
-	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -19,23 +19,9 @@ type MessageObject struct {
 
 func main() {
 
-	// The key for the map is message.to
-	clients := make(map[string]string)
-
 	// Start a new Fiber application
 	app := fiber.New()
 
-	// Setup the middleware to retrieve the data sent in first GET request
-	app.Use(func(c *fiber.Ctx) error {
-		// IsWebSocketUpgrade returns true if the client
-		// requested upgrade to the WebSocket protocol.
-		if websocket.IsWebSocketUpgrade(c) {
-			c.Locals("allowed", true)
-			return c.Next()
-		}
-		return fiber.ErrUpgradeRequired
-	})
-
 	// Multiple event handling supported
 	ikisocket.On(ikisocket.EventConnect, func(ep *ikisocket.EventPayload) {
 		fmt.Println(fmt.Sprintf("Connection event 1 - User: %s", ep.Kws.GetStringAttribute("user_id")))
@@ -64,8 +50,8 @@ func main() {
 			return
 		}
 
-		// Emit the message directly to specified user
-		err = ep.Kws.EmitTo(clients[message.To], ep.Data)
+		// Emit the message to all the connections of the specified user
+		err = ep.Kws.EmitToUser(message.To, ep.Data)
 		if err != nil {
 			fmt.Println(err)
 		}
@@ -73,16 +59,12 @@ func main() {
 
 	// On disconnect event
 	ikisocket.On(ikisocket.EventDisconnect, func(ep *ikisocket.EventPayload) {
-		// Remove the user from the local clients
-		delete(clients, ep.Kws.GetStringAttribute("user_id"))
 		fmt.Println(fmt.Sprintf("Disconnection event - User: %s", ep.Kws.GetStringAttribute("user_id")))
 	})
 
 	// On close event
 	// This event is called when the server disconnects the user actively with .Close() method
 	ikisocket.On(ikisocket.EventClose, func(ep *ikisocket.EventPayload) {
-		// Remove the user from the local clients
-		delete(clients, ep.Kws.GetStringAttribute("user_id"))
 		fmt.Println(fmt.Sprintf("Close event - User: %s", ep.Kws.GetStringAttribute("user_id")))
 	})
 
@@ -91,15 +73,12 @@ func main() {
 		fmt.Println(fmt.Sprintf("Error event - User: %s", ep.Kws.GetStringAttribute("user_id")))
 	})
 
-	app.Get("/ws/:id", ikisocket.New(func(kws *ikisocket.Websocket) {
-
-		// Retrieve the user id from endpoint
-		userId := kws.Params("id")
+	app.Get("/ws", ikisocket.New(func(kws *ikisocket.Websocket) {
 
-		// Add the connection to the list of the connected clients
-		// The UUID is generated randomly and is the key that allow
-		// ikisocket to manage Emit/EmitTo/Broadcast
-		clients[userId] = kws.UUID
+		// Retrieve the user id of the authenticated client,
+		// the connection is already associated to the user that
+		// may have several connections, one for every tab or device
+		userId := kws.Identity().UserID
 
 		// Every websocket connection has an optional session key => value storage
 		kws.SetAttribute("user_id", userId)
@@ -108,6 +87,11 @@ func main() {
 		kws.Broadcast([]byte(fmt.Sprintf("New user connected: %s and UUID: %s", userId, kws.UUID)), true)
 		//Write welcome message
 		kws.Emit([]byte(fmt.Sprintf("Hello user: %s with UUID: %s", userId, kws.UUID)))
+	}, ikisocket.Config{
+		// Identify the client before the upgrade verifying the JWT of the
+		// "token" query parameter or of the bearer authorization header,
+		// the "sub" claim is the user id
+		Authenticate: ikisocket.JWTHS256([]byte(os.Getenv("JWT_SECRET"))),
 	}))
 
 	log.Fatal(app.Listen(":3000"))
@@ -5,36 +5,34 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
 	"time"
 
 	"github.com/antoniodipinto/ikisocket"
-	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 )
 
 // MessageObject Basic chat message object
 type MessageObject struct {
-	Data string `json:"data"`
-	From string `json:"from"`
-	Room string `json:"room"`
-	To   string `json:"to"`
+	Data  string `json:"data"`
+	From  string `json:"from"`
+	Room  string `json:"room"`
+	To    string `json:"to"`
+	Join  string `json:"join"`
+	Leave string `json:"leave"`
 }
 
 // Room Chat Room message object
 type Room struct {
-	Name  string   `json:"name"`
-	UUID  string   `json:"uuid"`
-	Users []string `json:"users"`
+	Name    string   `json:"name"`
+	UUID    string   `json:"uuid"`
+	Members []string `json:"members"`
 }
 
-var clients map[string]string
 var rooms map[string]*Room
 
 func main() {
 
-	// The key for the map is message.to
-	clients = make(map[string]string)
-
 	// Rooms will be kept in memory as map
 	// for faster and easier handling
 	rooms = make(map[string]*Room)
@@ -68,9 +66,9 @@ func main() {
 		}
 
 		room := Room{
-			Name:  body.Name,
-			UUID:  generateRoomId(),
-			Users: nil,
+			Name:    body.Name,
+			UUID:    generateRoomId(),
+			Members: nil,
 		}
 
 		rooms[room.UUID] = &room
@@ -84,57 +82,16 @@ func main() {
 		return ctx.JSON(true)
 	})
 
-	// Joins a room passing:
-	// - Room ID (as room)
-	// - User ID (as user)
-	app.Post("/rooms/join", func(ctx *fiber.Ctx) error {
-		type request struct {
-			Room string `json:"room"`
-			User string `json:"user"`
-		}
-
-		// check for the incoming request body
-		body := new(request)
-		if err := ctx.BodyParser(&body); err != nil {
-			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "cannot parse JSON",
-			})
-		}
-
-		if body.User == "" || body.Room == "" {
-			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "invalid user or room",
-			})
-		}
-
-		rooms[body.Room].Users = append(rooms[body.Room].Users, body.User)
-		return ctx.JSON(true)
-	})
-
-	// Setup the middleware to retrieve the data sent in first GET request
-	app.Use(func(c *fiber.Ctx) error {
-		// IsWebSocketUpgrade returns true if the client
-		// requested upgrade to the WebSocket protocol.
-		if websocket.IsWebSocketUpgrade(c) {
-			c.Locals("allowed", true)
-			return c.Next()
-		}
-		return fiber.ErrUpgradeRequired
-	})
-
 	// Pull out in another function
 	// all the ikisocket callbacks and listeners
 	setupSocketListeners()
 
-	app.Get("/ws/:id", ikisocket.New(func(kws *ikisocket.Websocket) {
-
-		// Retrieve the user id from endpoint
-		userId := kws.Params("id")
+	app.Get("/ws", ikisocket.New(func(kws *ikisocket.Websocket) {
 
-		// Add the connection to the list of the connected clients
-		// The UUID is generated randomly and is the key that allow
-		// ikisocket to manage Emit/EmitTo/Broadcast
-		clients[userId] = kws.UUID
+		// Retrieve the user id of the authenticated client,
+		// the connection is already associated to the user that
+		// may have several connections, one for every tab or device
+		userId := kws.Identity().UserID
 
 		// Every websocket connection has an optional session key => value storage
 		kws.SetAttribute("user_id", userId)
@@ -143,6 +100,11 @@ func main() {
 		kws.Broadcast([]byte(fmt.Sprintf("New user connected: %s and UUID: %s", userId, kws.UUID)), true, ikisocket.TextMessage)
 		//Write welcome message
 		kws.Emit([]byte(fmt.Sprintf("Hello user: %s with UUID: %s", userId, kws.UUID)), ikisocket.TextMessage)
+	}, ikisocket.Config{
+		// Identify the client before the upgrade verifying the JWT of the
+		// "token" query parameter or of the bearer authorization header,
+		// the "sub" claim is the user id
+		Authenticate: ikisocket.JWTHS256([]byte(os.Getenv("JWT_SECRET"))),
 	}))
 
 	log.Fatal(app.Listen(":3000"))
@@ -163,10 +125,12 @@ func generateRoomId() string {
 }
 
 // for beautify purposes we clean the rooms object to a list of rooms
+// filled with the socket uuids of the members
 func beautifyRoomsObject(rooms map[string]*Room) []*Room {
 	var result []*Room
 
 	for _, room := range rooms {
+		room.Members = ikisocket.RoomMembers(room.UUID)
 		result = append(result, room)
 	}
 
@@ -200,49 +164,60 @@ func setupSocketListeners() {
 		//  "room": "<room-id>",
 		//  "data": "hello"
 		//}
+		// or join/leave a room
+		// {
+		//  "join": "<room-id>"
+		//}
 		err := json.Unmarshal(ep.Data, &message)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 
+		if message.Join != "" {
+			ep.Kws.Join(message.Join)
+			return
+		}
+
+		if message.Leave != "" {
+			ep.Kws.Leave(message.Leave)
+			return
+		}
+
 		// If the user is trying to send message
-		// into a specific group, iterate over the
-		// group user socket UUIDs
+		// into a specific group, emit the message
+		// to all the room participants
 		if message.Room != "" {
-			// Emit the message to all the room participants
-			// iterating on all the uuids
-			for _, userId := range rooms[message.Room].Users {
-				_ = ep.Kws.EmitTo(clients[userId], ep.Data, ikisocket.TextMessage)
-			}
-
-			// Other way can be used EmitToList method
-			// if you have a []string of ikisocket uuids
-			//
-			// ep.Kws.EmitToList(list, data)
-			//
+			ep.Kws.EmitToRoom(message.Room, ep.Data, false, ikisocket.TextMessage)
 			return
 		}
 
-		// Emit the message directly to specified user
-		err = ep.Kws.EmitTo(clients[message.To], ep.Data, ikisocket.TextMessage)
+		// Emit the message to all the connections of the specified user
+		err = ep.Kws.EmitToUser(message.To, ep.Data, ikisocket.TextMessage)
 		if err != nil {
 			fmt.Println(err)
 		}
 	})
 
+	// On join event
+	ikisocket.On(ikisocket.EventJoin, func(ep *ikisocket.EventPayload) {
+		fmt.Println(fmt.Sprintf("Join event - User: %s - Room: %s", ep.Kws.GetStringAttribute("user_id"), string(ep.Data)))
+	})
+
+	// On leave event
+	// Fired also when the user disconnects, rooms are left automatically
+	ikisocket.On(ikisocket.EventLeave, func(ep *ikisocket.EventPayload) {
+		fmt.Println(fmt.Sprintf("Leave event - User: %s - Room: %s", ep.Kws.GetStringAttribute("user_id"), string(ep.Data)))
+	})
+
 	// On disconnect event
 	ikisocket.On(ikisocket.EventDisconnect, func(ep *ikisocket.EventPayload) {
-		// Remove the user from the local clients
-		delete(clients, ep.Kws.GetStringAttribute("user_id"))
 		fmt.Println(fmt.Sprintf("Disconnection event - User: %s", ep.Kws.GetStringAttribute("user_id")))
 	})
 
 	// On close event
 	// This event is called when the server disconnects the user actively with .Close() method
 	ikisocket.On(ikisocket.EventClose, func(ep *ikisocket.EventPayload) {
-		// Remove the user from the local clients
-		delete(clients, ep.Kws.GetStringAttribute("user_id"))
 		fmt.Println(fmt.Sprintf("Close event - User: %s", ep.Kws.GetStringAttribute("user_id")))
 	})
 
@@ -4,45 +4,39 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/antoniodipinto/ikisocket"
-	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 )
 
 // MessageObject Basic chat message object
 type MessageObject struct {
-	Data  string `json:"data"`
-	From  string `json:"from"`
-	Event string `json:"event"`
-	To    string `json:"to"`
+	Data string `json:"data"`
+	From string `json:"from"`
+	To   string `json:"to"`
 }
 
 func main() {
 
-	// The key for the map is message.to
-	clients := make(map[string]string)
-
 	// Start a new Fiber application
 	app := fiber.New()
 
-	// Setup the middleware to retrieve the data sent in first GET request
-	app.Use(func(c *fiber.Ctx) error {
-		// IsWebSocketUpgrade returns true if the client
-		// requested upgrade to the WebSocket protocol.
-		if websocket.IsWebSocketUpgrade(c) {
-			c.Locals("allowed", true)
-			return c.Next()
-		}
-		return fiber.ErrUpgradeRequired
-	})
-
 	// Multiple event handling supported
 	ikisocket.On(ikisocket.EventConnect, func(ep *ikisocket.EventPayload) {
 		fmt.Println(fmt.Sprintf("Connection event 1 - User: %s", ep.Kws.GetStringAttribute("user_id")))
 	})
 
 	// Custom event handling supported
+	// The client fires it sending the envelope
+	// {
+	//  "event": "CUSTOM_EVENT",
+	//  "data": {
+	//    "from": "<user-id>",
+	//    "to": "<recipient-user-id>",
+	//    "data": "hello"
+	//  }
+	//}
 	ikisocket.On("CUSTOM_EVENT", func(ep *ikisocket.EventPayload) {
 		fmt.Println(fmt.Sprintf("Custom event - User: %s", ep.Kws.GetStringAttribute("user_id")))
 		// --->
@@ -50,53 +44,36 @@ func main() {
 		// DO YOUR BUSINESS HERE
 
 		// --->
-	})
-
-	// On message event
-	ikisocket.On(ikisocket.EventMessage, func(ep *ikisocket.EventPayload) {
-
-		fmt.Println(fmt.Sprintf("Message event - User: %s - Message: %s", ep.Kws.GetStringAttribute("user_id"), string(ep.Data)))
 
+		// The data of the envelope is provided as payload data
 		message := MessageObject{}
-
-		// Unmarshal the json message
-		// {
-		//  "from": "<user-id>",
-		//  "to": "<recipient-user-id>",
-		//  "event": "CUSTOM_EVENT",
-		//  "data": "hello"
-		//}
 		err := json.Unmarshal(ep.Data, &message)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 
-		// Fire custom event based on some
-		// business logic
-		if message.Event != "" {
-			ep.Kws.Fire(message.Event, []byte(message.Data))
-		}
-
-		// Emit the message directly to specified user
-		err = ep.Kws.EmitTo(clients[message.To], ep.Data, ikisocket.TextMessage)
+		// Emit the message to all the connections of the specified user
+		err = ep.Kws.EmitToUser(message.To, ep.Data, ikisocket.TextMessage)
 		if err != nil {
 			fmt.Println(err)
 		}
 	})
 
+	// On message event
+	// Fired for the raw messages and for the envelopes without event
+	ikisocket.On(ikisocket.EventMessage, func(ep *ikisocket.EventPayload) {
+		fmt.Println(fmt.Sprintf("Message event - User: %s - Message: %s", ep.Kws.GetStringAttribute("user_id"), string(ep.Data)))
+	})
+
 	// On disconnect event
 	ikisocket.On(ikisocket.EventDisconnect, func(ep *ikisocket.EventPayload) {
-		// Remove the user from the local clients
-		delete(clients, ep.Kws.GetStringAttribute("user_id"))
 		fmt.Println(fmt.Sprintf("Disconnection event - User: %s", ep.Kws.GetStringAttribute("user_id")))
 	})
 
 	// On close event
 	// This event is called when the server disconnects the user actively with .Close() method
 	ikisocket.On(ikisocket.EventClose, func(ep *ikisocket.EventPayload) {
-		// Remove the user from the local clients
-		delete(clients, ep.Kws.GetStringAttribute("user_id"))
 		fmt.Println(fmt.Sprintf("Close event - User: %s", ep.Kws.GetStringAttribute("user_id")))
 	})
 
@@ -105,15 +82,12 @@ func main() {
 		fmt.Println(fmt.Sprintf("Error event - User: %s", ep.Kws.GetStringAttribute("user_id")))
 	})
 
-	app.Get("/ws/:id", ikisocket.New(func(kws *ikisocket.Websocket) {
-
-		// Retrieve the user id from endpoint
-		userId := kws.Params("id")
+	app.Get("/ws", ikisocket.New(func(kws *ikisocket.Websocket) {
 
-		// Add the connection to the list of the connected clients
-		// The UUID is generated randomly and is the key that allow
-		// ikisocket to manage Emit/EmitTo/Broadcast
-		clients[userId] = kws.UUID
+		// Retrieve the user id of the authenticated client,
+		// the connection is already associated to the user that
+		// may have several connections, one for every tab or device
+		userId := kws.Identity().UserID
 
 		// Every websocket connection has an optional session key => value storage
 		kws.SetAttribute("user_id", userId)
@@ -122,6 +96,14 @@ func main() {
 		kws.Broadcast([]byte(fmt.Sprintf("New user connected: %s and UUID: %s", userId, kws.UUID)), true, ikisocket.TextMessage)
 		//Write welcome message
 		kws.Emit([]byte(fmt.Sprintf("Hello user: %s with UUID: %s", userId, kws.UUID)), ikisocket.TextMessage)
+	}, ikisocket.Config{
+		// Decode the {"event": "...", "data": ...} messages
+		// and fire the named events
+		Envelope: true,
+		// Identify the client before the upgrade verifying the JWT of the
+		// "token" query parameter or of the bearer authorization header,
+		// the "sub" claim is the user id
+		Authenticate: ikisocket.JWTHS256([]byte(os.Getenv("JWT_SECRET"))),
 	}))
 
 	log.Fatal(app.Listen(":3000"))
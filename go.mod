@@ -10,4 +10,5 @@ require (
 	github.com/rivo/uniseg v0.4.4 // indirect
 	github.com/stretchr/testify v1.8.4
 	github.com/valyala/fasthttp v1.50.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
 )
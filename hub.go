@@ -0,0 +1,317 @@
+package ikisocket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Hub Independent registry of connections and event listeners.
+// Connections created through a hub are only reachable by
+// Broadcast/EmitTo/Fire of the same hub, and only the listeners
+// registered with hub.On receive their events.
+type Hub struct {
+	mu sync.RWMutex
+	// Unique id of the hub in the cluster
+	node string
+	// Optional adapter reaching the other nodes
+	adapter Adapter
+	// Optional directory of the connection owners
+	directory     Directory
+	directoryTTL  time.Duration
+	stopDirectory chan struct{}
+	// Pool with the active connections
+	pool *safePool
+	// List of the listeners for the events
+	listeners *safeListeners
+	// Rooms joined by the connections
+	rooms *safeRooms
+	// Sessions waiting for the client to reconnect
+	sessions *safeSessions
+	// Online users, created on first use
+	presence *Presence
+	// Worker pools of the endpoints using DispatchPool
+	workers []*workerPool
+	// Middlewares of the event pipeline
+	middlewares      []Middleware
+	eventMiddlewares []eventMiddleware
+	// Set by Shutdown, the new upgrades are rejected
+	closing bool
+	// Connections whose run loop has not returned yet
+	running sync.WaitGroup
+	// Settings applied to the endpoints of the hub
+	config Config
+}
+
+// Hub used by the package level functions
+var defaultHub = &Hub{
+	node:      uuid.New().String(),
+	pool:      &pool,
+	listeners: &listeners,
+	rooms:     newSafeRooms(),
+	sessions:  newSafeSessions(),
+}
+
+// NewHub Create a new hub with its own pool and listeners.
+// Panics if the config is not valid.
+func NewHub(config ...Config) *Hub {
+	var cfg Config
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if err := configDefault(cfg).Validate(); err != nil {
+		panic(err)
+	}
+
+	return &Hub{
+		node:   uuid.New().String(),
+		config: cfg,
+		pool: &safePool{
+			conn: make(map[string]ws),
+		},
+		listeners: &safeListeners{
+			list: make(map[string][]listener),
+		},
+		rooms:    newSafeRooms(),
+		sessions: newSafeSessions(),
+	}
+}
+
+// New Create a websocket handler registered in the hub.
+// The optional config replaces the hub one for this endpoint.
+// Panics if the config is not valid.
+func (h *Hub) New(callback func(kws *Websocket), config ...Config) func(*fiber.Ctx) error {
+	cfg := h.config
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	resolved := configDefault(cfg)
+	if err := resolved.Validate(); err != nil {
+		panic(err)
+	}
+
+	// the connections of the endpoint share the same pool
+	var workers *workerPool
+	if resolved.Dispatch == DispatchPool {
+		workers = newWorkerPool(resolved.DispatchWorkers, resolved.DispatchQueueSize)
+		h.mu.Lock()
+		h.workers = append(h.workers, workers)
+		h.mu.Unlock()
+	}
+
+	handler := websocket.New(func(c *websocket.Conn) {
+		// the hub started the shutdown after the upgrade
+		if !h.track() {
+			refuse(c)
+			return
+		}
+		defer h.running.Done()
+
+		if resolved.ReadLimit > 0 {
+			c.SetReadLimit(resolved.ReadLimit)
+		}
+
+		kws := &Websocket{
+			hub:    h,
+			config: resolved,
+			Conn:   c,
+			Locals: func(key string) interface{} {
+				return c.Locals(key)
+			},
+			Params: func(key string, defaultValue ...string) string {
+				return c.Params(key, defaultValue...)
+			},
+			Query: func(key string, defaultValue ...string) string {
+				return c.Query(key, defaultValue...)
+			},
+			Cookies: func(key string, defaultValue ...string) string {
+				return c.Cookies(key, defaultValue...)
+			},
+			queue:      make(chan message, resolved.QueueSize),
+			done:       make(chan struct{}, 1),
+			attributes: make(map[string]interface{}),
+			isAlive:    true,
+		}
+		kws.events.mode = resolved.Dispatch
+		kws.events.workers = workers
+		kws.events.size = resolved.DispatchQueueSize
+
+		// Generate uuid
+		kws.UUID = kws.createUUID()
+
+		if identity, ok := c.Locals(identityLocal).(Identity); ok {
+			kws.identity = identity
+		}
+
+		// resume the session of a reconnecting client
+		if resolved.SessionTTL > 0 {
+			kws.openSession(c.Query(SessionTokenQuery), c.Query(SessionSeqQuery))
+		}
+
+		// register the connection into the pool
+		h.pool.set(kws)
+
+		if userID, _ := kws.identifiedUser(); userID != "" {
+			kws.SetUserID(userID)
+		}
+
+		// execute the callback of the socket initialization
+		callback(kws)
+
+		if kws.resumed {
+			kws.restoreSession()
+		}
+
+		// claim the final uuid in the cluster
+		kws.register()
+
+		kws.fireEvent(EventConnect, nil, nil)
+		if kws.resumed {
+			kws.fireEvent(EventResume, nil, nil)
+		}
+
+		// Run the loop for the given connection
+		kws.run()
+	})
+
+	return func(c *fiber.Ctx) error {
+		if !h.accepting() {
+			return fiber.ErrServiceUnavailable
+		}
+		if resolved.Authenticate != nil {
+			if !websocket.IsWebSocketUpgrade(c) {
+				return fiber.ErrUpgradeRequired
+			}
+			if err := authenticate(c, resolved.Authenticate); err != nil {
+				return err
+			}
+		}
+		return handler(c)
+	}
+}
+
+// On Add listener callback for an event into the hub listeners list,
+// the returned id removes it with Off. The event can be a pattern as
+// "chat.*" or "room:*:message", "*" listens to every event.
+func (h *Hub) On(event string, callback eventCallback) ListenerID {
+	return h.listeners.set(event, callback, false)
+}
+
+// Once Add listener callback for an event, removed after the first call
+func (h *Hub) Once(event string, callback eventCallback) ListenerID {
+	return h.listeners.set(event, callback, true)
+}
+
+// Off Remove the listener of an event,
+// returns false if the listener was not registered
+func (h *Hub) Off(event string, id ListenerID) bool {
+	return h.listeners.remove(event, id)
+}
+
+// RemoveAllListeners Remove all the listeners of an event
+func (h *Hub) RemoveAllListeners(event string) {
+	h.listeners.removeAll(event)
+}
+
+// EmitTo Emit to a specific socket connection of the hub.
+// With an adapter the connections of the other nodes are reached too:
+// with a directory the message is routed to the owner node only,
+// otherwise it is sent to every node without knowing if the uuid exists.
+func (h *Hub) EmitTo(uuid string, message []byte, mType ...int) error {
+	err := h.emitLocal(uuid, message, mType...)
+	if err != ErrorInvalidConnection || h.pool.contains(uuid) || h.getAdapter() == nil {
+		return err
+	}
+
+	msg := AdapterMessage{
+		Kind:   AdapterEmit,
+		Target: uuid,
+		Data:   message,
+		MType:  messageType(mType),
+	}
+
+	if directory, _ := h.getDirectory(); directory != nil {
+		node, err := h.lookup(uuid)
+		if err != nil {
+			return err
+		}
+		// unknown, expired or stale entry of this node
+		if node == "" || node == h.node {
+			return ErrorInvalidConnection
+		}
+		msg.To = node
+	}
+
+	return h.publish(msg)
+}
+
+// Emit to a connection of the local pool
+func (h *Hub) emitLocal(uuid string, message []byte, mType ...int) error {
+	kws, ok := h.pool.lookup(uuid)
+	if !ok || !kws.IsAlive() {
+		// kept for the replay when the client reconnects
+		if h.emitParked(uuid, message, mType...) {
+			return nil
+		}
+		return ErrorInvalidConnection
+	}
+
+	kws.Emit(message, mType...)
+	return nil
+}
+
+// EmitToList Emit the message to a specific socket uuids list
+// Ignores all errors
+func (h *Hub) EmitToList(uuids []string, message []byte, mType ...int) {
+	for _, wsUUID := range uuids {
+		_ = h.EmitTo(wsUUID, message, mType...)
+	}
+}
+
+// Broadcast to all the active connections of the hub,
+// and of the other nodes when there is an adapter
+func (h *Hub) Broadcast(message []byte, mType ...int) {
+	h.broadcastLocal(message, mType...)
+	_ = h.publish(AdapterMessage{
+		Kind:  AdapterBroadcast,
+		Data:  message,
+		MType: messageType(mType),
+	})
+}
+
+func (h *Hub) broadcastLocal(message []byte, mType ...int) {
+	for _, kws := range h.pool.all() {
+		kws.Emit(message, mType...)
+	}
+	h.broadcastParked(message, "", mType...)
+}
+
+// Fire custom event on all the connections of the hub,
+// and of the other nodes when there is an adapter
+func (h *Hub) Fire(event string, data []byte) {
+	h.fireGlobalEvent(event, data, nil)
+	_ = h.publish(AdapterMessage{
+		Kind:  AdapterFire,
+		Event: event,
+		Data:  data,
+	})
+}
+
+// Fires event on all connections, with an asynchronous
+// Dispatch the connections handle it concurrently
+func (h *Hub) fireGlobalEvent(event string, data []byte, error error) {
+	for _, kws := range h.pool.all() {
+		kws.fireEvent(event, data, error)
+	}
+}
+
+// The message type of the optional argument, TextMessage by default
+func messageType(mType []int) int {
+	if len(mType) > 0 {
+		return mType[0]
+	}
+	return TextMessage
+}
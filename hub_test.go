@@ -0,0 +1,286 @@
+package ikisocket
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHubIsolation(t *testing.T) {
+	customers := NewHub()
+	operators := NewHub()
+
+	for i := 0; i < numTestConn; i++ {
+		createWS(withHub(customers))
+	}
+
+	createWS(withHub(operators))
+
+	customerHandler := new(HandlerMock)
+	customerHandler.On("OnCustomEvent", mock.Anything).Return(nil)
+	customerHandler.wg.Add(numTestConn)
+
+	operatorHandler := new(HandlerMock)
+	operatorHandler.On("OnCustomEvent", mock.Anything).Return(nil)
+	operatorHandler.wg.Add(1)
+
+	customers.On("hubevent", customerHandler.OnCustomEvent)
+	operators.On("hubevent", operatorHandler.OnCustomEvent)
+
+	customers.Fire("hubevent", []byte("test"))
+	customerHandler.wg.Wait()
+
+	customerHandler.AssertNumberOfCalls(t, "OnCustomEvent", numTestConn)
+	operatorHandler.AssertNumberOfCalls(t, "OnCustomEvent", 0)
+
+	operators.Fire("hubevent", []byte("test"))
+	operatorHandler.wg.Wait()
+
+	customerHandler.AssertNumberOfCalls(t, "OnCustomEvent", numTestConn)
+	operatorHandler.AssertNumberOfCalls(t, "OnCustomEvent", 1)
+}
+
+func TestHubEmitTo(t *testing.T) {
+	h := NewHub()
+
+	alive := new(WebsocketMock)
+	alive.UUID = "80a80sdf809dsf"
+	alive.On("Emit", mock.Anything).Return(nil)
+	alive.On("IsAlive").Return(true)
+	h.pool.set(alive)
+
+	// not reachable from the default hub
+	require.Equal(t, ErrorInvalidConnection, EmitTo(alive.UUID, []byte("test")))
+
+	alive.wg.Add(1)
+	require.Nil(t, h.EmitTo(alive.UUID, []byte("test")))
+	alive.wg.Wait()
+
+	alive.AssertNumberOfCalls(t, "Emit", 1)
+}
+
+func TestHubEmitToRemoved(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h), withQueue(100000))
+
+	// the connection leaves the pool while it is emitted to
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100000; i++ {
+			h.pool.delete(kws.GetUUID())
+			h.pool.set(kws)
+		}
+	}()
+
+	for i := 0; i < 100000; i++ {
+		err := h.EmitTo(kws.GetUUID(), []byte("test"))
+		if err != nil {
+			require.Equal(t, ErrorInvalidConnection, err)
+		}
+	}
+	<-done
+}
+
+func TestWebsocket_SetUUID(t *testing.T) {
+	h := NewHub()
+
+	kws := createWS(withHub(h))
+
+	previous := kws.GetUUID()
+	kws.SetUUID("custom-uuid")
+
+	require.False(t, h.pool.contains(previous))
+	require.True(t, h.pool.contains("custom-uuid"))
+	require.Equal(t, kws, h.pool.get("custom-uuid"))
+
+	assertPanic(t, func() {
+		kws.SetUUID("custom-uuid")
+	})
+}
+
+func TestHubOffOnce(t *testing.T) {
+	h := NewHub()
+
+	createWS(withHub(h))
+
+	calls := make(map[string]int)
+	first := h.On("event", func(payload *EventPayload) {
+		calls["first"]++
+	})
+	h.On("event", func(payload *EventPayload) {
+		calls["second"]++
+	})
+	h.Once("event", func(payload *EventPayload) {
+		calls["once"]++
+	})
+
+	h.Fire("event", nil)
+	require.Equal(t, map[string]int{"first": 1, "second": 1, "once": 1}, calls)
+
+	require.True(t, h.Off("event", first))
+	require.False(t, h.Off("event", first))
+
+	h.Fire("event", nil)
+	require.Equal(t, map[string]int{"first": 1, "second": 2, "once": 1}, calls)
+
+	h.RemoveAllListeners("event")
+	h.Fire("event", nil)
+	require.Equal(t, map[string]int{"first": 1, "second": 2, "once": 1}, calls)
+	require.Empty(t, h.listeners.list)
+}
+
+func TestHubOnceConcurrent(t *testing.T) {
+	h := NewHub()
+
+	for i := 0; i < numTestConn; i++ {
+		createWS(withHub(h))
+	}
+
+	var calls int32
+	h.Once("event", func(payload *EventPayload) {
+		atomic.AddInt32(&calls, 1)
+	})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < numTestConn; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Fire("event", nil)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, int32(1), calls)
+}
+
+func TestWebsocket_On(t *testing.T) {
+	h := NewHub()
+
+	first := createWS(withHub(h), withDone())
+
+	second := createWS(withHub(h))
+
+	var calls []string
+	h.On("event", func(payload *EventPayload) {
+		calls = append(calls, "global "+payload.SocketUUID)
+	})
+	first.On("event", func(payload *EventPayload) {
+		calls = append(calls, "connection "+payload.SocketUUID)
+	})
+	first.On(EventDisconnect, func(payload *EventPayload) {
+		calls = append(calls, "disconnect "+payload.SocketUUID)
+	})
+
+	first.Fire("event", nil)
+	second.Fire("event", nil)
+	require.Equal(t, []string{
+		"global " + first.UUID,
+		"connection " + first.UUID,
+		"global " + second.UUID,
+	}, calls)
+
+	// released on disconnection
+	calls = nil
+	first.disconnected(nil)
+	first.Fire("event", nil)
+	require.Equal(t, []string{
+		"disconnect " + first.UUID,
+		"global " + first.UUID,
+	}, calls)
+}
+
+func TestMatchEvent(t *testing.T) {
+	cases := []struct {
+		pattern string
+		event   string
+		match   bool
+	}{
+		{"*", "message", true},
+		{"*", "room:lobby:message", true},
+		{"chat.*", "chat.message", true},
+		{"chat.*", "chat", false},
+		{"chat.*", "chat.room.message", false},
+		{"chat.*", "chat:message", false},
+		{"room:*:message", "room:lobby:message", true},
+		{"room:*:message", "room:lobby:join", false},
+		{"room:lobby:*", "room:lobby:message", true},
+		{"*.created", "user.created", true},
+		{"*.created", "user.deleted", false},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.match, matchEvent(c.pattern, c.event), c.pattern+" "+c.event)
+	}
+
+	require.True(t, isPattern("*"))
+	require.True(t, isPattern("room:*:message"))
+	require.False(t, isPattern("chat*"))
+}
+
+func TestWildcardListeners(t *testing.T) {
+	h := NewHub()
+
+	createWS(withHub(h))
+
+	var calls []string
+	h.On("chat.*", func(payload *EventPayload) {
+		calls = append(calls, "chat.* "+payload.Name)
+	})
+	h.On("chat.message", func(payload *EventPayload) {
+		calls = append(calls, "chat.message "+payload.Name)
+	})
+	all := h.On("*", func(payload *EventPayload) {
+		calls = append(calls, "* "+payload.Name)
+	})
+	h.Once("room:*:message", func(payload *EventPayload) {
+		calls = append(calls, "room:*:message "+payload.Name)
+	})
+
+	// registration order across names and patterns
+	h.Fire("chat.message", nil)
+	require.Equal(t, []string{
+		"chat.* chat.message",
+		"chat.message chat.message",
+		"* chat.message",
+	}, calls)
+
+	calls = nil
+	h.Fire("room:lobby:message", nil)
+	h.Fire("room:lobby:message", nil)
+	require.Equal(t, []string{
+		"* room:lobby:message",
+		"room:*:message room:lobby:message",
+		"* room:lobby:message",
+	}, calls)
+
+	// the cached matches are refreshed when the patterns change
+	calls = nil
+	require.True(t, h.Off("*", all))
+	h.Fire("chat.joined", nil)
+	require.Equal(t, []string{"chat.* chat.joined"}, calls)
+	require.Len(t, h.listeners.patterns, 1)
+}
+
+func TestHubPatternCache(t *testing.T) {
+	h := NewHub()
+	h.On("chat.*", func(payload *EventPayload) {})
+
+	// the names without listeners are not cached
+	for i := 0; i < 10; i++ {
+		require.Empty(t, h.listeners.get("other."+strconv.Itoa(i)))
+	}
+	require.Nil(t, h.listeners.matches.items)
+
+	// the least recently used names are removed
+	for i := 0; i <= maxCachedMatches; i++ {
+		require.Len(t, h.listeners.get("chat."+strconv.Itoa(i)), 1)
+	}
+	require.Len(t, h.listeners.matches.items, maxCachedMatches)
+	require.NotContains(t, h.listeners.matches.items, "chat.0")
+	require.Contains(t, h.listeners.matches.items, "chat."+strconv.Itoa(maxCachedMatches))
+}
@@ -1,9 +1,13 @@
 package ikisocket
 
 import (
+	"container/list"
 	"context"
 	"errors"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
@@ -35,9 +39,10 @@ const (
 const (
 	// EventMessage Fired when a Text/Binary message is received
 	EventMessage = "message"
-	// EventPing More details here:
+	// EventPing Fired when the client sends a ping control message. More details here:
 	// @url https://developer.mozilla.org/en-US/docs/Web/API/WebSockets_API/Writing_WebSocket_servers#Pings_and_Pongs_The_Heartbeat_of_WebSockets
 	EventPing = "ping"
+	// EventPong Fired when the client answers to the server ping
 	EventPong = "pong"
 	// EventDisconnect Fired on disconnection
 	// The error provided in disconnection event
@@ -46,10 +51,27 @@ const (
 	EventDisconnect = "disconnect"
 	// EventConnect Fired on first connection
 	EventConnect = "connect"
-	// EventClose Fired when the connection is actively closed from the server
+	// EventClose Fired when the connection is actively closed from the server,
+	// once the close frame is queued
 	EventClose = "close"
 	// EventError Fired when some error appears useful also for debugging websockets
 	EventError = "error"
+	// EventJoin Fired when the connection joins a room,
+	// the room name is provided as data
+	EventJoin = "join"
+	// EventLeave Fired when the connection leaves a room,
+	// also when it is removed from its rooms on disconnection
+	EventLeave = "leave"
+	// EventResume Fired after EventConnect when the connection
+	// resumed a previous session, see Config.SessionTTL
+	EventResume = "resume"
+	// EventOnline Fired when the first connection of a user is tracked
+	// by the presence, the user id is provided as data. The users of the
+	// other nodes are passed to Presence.OnChange.
+	EventOnline = "online"
+	// EventOffline Fired when the last connection of a user is
+	// disconnected, the user id is provided as data
+	EventOffline = "offline"
 )
 
 var (
@@ -58,15 +80,32 @@ var (
 	ErrorInvalidConnection = errors.New("message cannot be delivered invalid/gone connection")
 	// ErrorUUIDDuplication The UUID already exists in the pool
 	ErrorUUIDDuplication = errors.New("UUID already exists in the available connections pool")
+	// ErrorQueueFull The message has been dropped or the connection
+	// disconnected because its queue is full, see Config.OverflowPolicy
+	ErrorQueueFull = errors.New("message queue of the connection is full")
+	// ErrorHeartbeatTimeout The client did not answer to the ping
+	// control messages within the Config.PongDeadline
+	ErrorHeartbeatTimeout = errors.New("heartbeat timeout, no pong received from the client")
 )
 
+// Package level defaults, applied to every Config
+// that leaves the related field empty
 var (
+	// PongTimeout interval between the ping control messages sent to the client
+	//
+	// Deprecated: use Config.PingInterval
 	PongTimeout = 1 * time.Second
 	// RetrySendTimeout retry after 20 ms if there is an error
+	//
+	// Deprecated: use Config.RetrySendTimeout
 	RetrySendTimeout = 20 * time.Millisecond
 	//MaxSendRetry define max retries if there are socket issues
+	//
+	// Deprecated: use Config.MaxSendRetry
 	MaxSendRetry = 5
 	// ReadTimeout Instead of reading in a for loop, try to avoid full CPU load taking some pause
+	//
+	// Deprecated: the read loop blocks on the connection, the value is ignored
 	ReadTimeout = 10 * time.Millisecond
 )
 
@@ -78,6 +117,8 @@ type message struct {
 	data []byte
 	// Message send retries when error
 	retries int
+	// Set for the messages without a session sequence number
+	unsequenced bool
 }
 
 // EventPayload Event Payload is the object that
@@ -90,7 +131,7 @@ type EventPayload struct {
 	Name string
 	// Unique connection UUID
 	SocketUUID string
-	// Optional websocket attributes
+	// Optional websocket attributes, copied when the event is fired
 	SocketAttributes map[string]interface{}
 	// Optional error when are fired events like
 	// - Disconnect
@@ -98,6 +139,17 @@ type EventPayload struct {
 	Error error
 	// Data is used on Message and on Error event
 	Data []byte
+	// Close code sent by the client, set on the Disconnect event
+	// when the client closed the connection with a close frame
+	CloseCode CloseCode
+	// Close reason sent by the client, set with CloseCode
+	CloseReason string
+	// Id of the inbound envelope expecting an acknowledgement
+	ackID uint64
+	// JSON data of the inbound envelope, Data has the JSON strings unquoted
+	raw []byte
+	// Data as decoded from the envelope
+	unquoted []byte
 }
 
 type ws interface {
@@ -114,7 +166,7 @@ type ws interface {
 	Fire(event string, data []byte)
 	Emit(message []byte, mType ...int)
 	Close()
-	pong(ctx context.Context)
+	heartbeat(ctx context.Context)
 	write(messageType int, messageBytes []byte)
 	run()
 	read(ctx context.Context)
@@ -125,13 +177,50 @@ type ws interface {
 }
 
 type Websocket struct {
-	mu sync.RWMutex
+	// Number of messages dropped because the queue was full,
+	// first fields to keep the 64-bit alignment for atomic operations
+	dropped uint64
+	// Last id used for the envelopes expecting an acknowledgement
+	lastID uint64
+	// Last sequence number of the reliable messages
+	reliableSeq uint64
+	mu          sync.RWMutex
+	// The hub the connection belongs to
+	hub *Hub
+	// Settings of the connection
+	config Config
 	// The Fiber.Websocket connection
 	Conn *websocket.Conn
 	// Define if the connection is alive or not
 	isAlive bool
+	// Set when the close frame has been queued
+	closing bool
+	// Resumable session, when the sessions are enabled
+	session *session
+	// Set when the connection resumed a previous session
+	resumed bool
+	// Set when the session is kept after the disconnection
+	parked bool
+	// User of the connection, set with SetUserID
+	userID string
+	// Serializes the user changes with the disconnection
+	userMu sync.Mutex
+	// Serializes the joins with the disconnection
+	roomsMu sync.Mutex
+	// Client identified by Config.Authenticate
+	identity Identity
 	// Queue of messages sent from the socket
 	queue chan message
+	// Pending acknowledgements of EmitWithAck
+	acks safeAcks
+	// Reliable messages waiting for the acknowledgement
+	reliable safeReliable
+	// Last inbound envelope ids, used to discard the duplicates
+	seen safeSeen
+	// Listeners of the events of this connection only
+	listeners safeListeners
+	// Scheduler of the events of this connection
+	events eventQueue
 	// Channel to signal when this websocket is closed
 	// so go routines will stop gracefully
 	done chan struct{}
@@ -153,9 +242,13 @@ type safePool struct {
 	sync.RWMutex
 	// List of the connections alive
 	conn map[string]ws
+	// Connection uuids of every user
+	users map[string]map[string]struct{}
+	// User of every connection uuid
+	userOf map[string]string
 }
 
-// Pool with the active connections
+// Pool with the active connections of the default hub
 var pool = safePool{
 	conn: make(map[string]ws),
 }
@@ -186,6 +279,14 @@ func (p *safePool) get(key string) ws {
 	return ret
 }
 
+// Get the connection if it is in the pool
+func (p *safePool) lookup(key string) (ws, bool) {
+	p.RLock()
+	defer p.RUnlock()
+	ret, ok := p.conn[key]
+	return ret, ok
+}
+
 func (p *safePool) contains(key string) bool {
 	p.RLock()
 	_, ok := p.conn[key]
@@ -196,81 +297,378 @@ func (p *safePool) contains(key string) bool {
 func (p *safePool) delete(key string) {
 	p.Lock()
 	delete(p.conn, key)
+	p.unsetUser(key)
+	p.Unlock()
+}
+
+// Move the connection stored under a key to a new key
+func (p *safePool) rename(from string, to string) {
+	p.Lock()
+	if kws, ok := p.conn[from]; ok {
+		delete(p.conn, from)
+		p.conn[to] = kws
+	}
+	if userID, ok := p.userOf[from]; ok {
+		p.unsetUser(from)
+		p.setUser(to, userID)
+	}
 	p.Unlock()
 }
 
 func (p *safePool) reset() {
 	p.Lock()
 	p.conn = make(map[string]ws)
+	p.users = nil
+	p.userOf = nil
 	p.Unlock()
 }
 
+// Index the connection uuid under the user, an empty user removes it.
+// The connections no longer in the pool are not indexed.
+func (p *safePool) user(key string, userID string) {
+	p.Lock()
+	defer p.Unlock()
+	p.unsetUser(key)
+	if _, ok := p.conn[key]; ok && userID != "" {
+		p.setUser(key, userID)
+	}
+}
+
+// The connection uuids of the user
+func (p *safePool) byUser(userID string) []string {
+	p.RLock()
+	defer p.RUnlock()
+	return sortedKeys(p.users[userID])
+}
+
+// Must be called with the lock held
+func (p *safePool) setUser(key string, userID string) {
+	if p.users == nil {
+		p.users = make(map[string]map[string]struct{})
+		p.userOf = make(map[string]string)
+	}
+	if _, ok := p.users[userID]; !ok {
+		p.users[userID] = make(map[string]struct{})
+	}
+	p.users[userID][key] = struct{}{}
+	p.userOf[key] = userID
+}
+
+// Must be called with the lock held
+func (p *safePool) unsetUser(key string) {
+	userID, ok := p.userOf[key]
+	if !ok {
+		return
+	}
+	delete(p.userOf, key)
+	delete(p.users[userID], key)
+	if len(p.users[userID]) == 0 {
+		delete(p.users, userID)
+	}
+}
+
+// Listeners of the events, registered by event name or by pattern.
+//
+// Event names are split in segments by "." and ":" (e.g. "chat.message"
+// or "room:lobby:message"), a "*" segment of a pattern matches exactly one
+// segment and the "*" pattern alone matches every event. The callbacks are
+// returned in registration order, the patterns matching an event name are
+// resolved once and cached until the patterns change.
 type safeListeners struct {
 	sync.RWMutex
-	list map[string][]eventCallback
+	// Listeners by event name or pattern
+	list map[string][]listener
+	// Registered patterns with the number of listeners
+	patterns map[string]int
+	// Patterns matching an event name
+	matches matchCache
 }
 
-func (l *safeListeners) set(event string, callback eventCallback) {
+// Registered callback of an event
+type listener struct {
+	id       ListenerID
+	callback eventCallback
+	// Removed after the first call
+	once bool
+}
+
+// ListenerID Handle of a registered listener, used to remove it with Off
+type ListenerID uint64
+
+// Last id assigned to a listener, shared by all the hubs
+var lastListenerID uint64
+
+// Max number of event names with cached pattern matches
+const maxCachedMatches = 1024
+
+// Patterns matching the event names, the least recently used
+// names are removed when the cache is full. It has its own lock
+// so the readers of the listeners can fill it.
+type matchCache struct {
+	sync.Mutex
+	// Cached names, the most recently used first
+	order *list.List
+	items map[string]*list.Element
+}
+
+type matchEntry struct {
+	event string
+	keys  []string
+}
+
+func (c *matchCache) get(event string) ([]string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	elem, ok := c.items[event]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*matchEntry).keys, true
+}
+
+func (c *matchCache) add(event string, keys []string, size int) {
+	c.Lock()
+	defer c.Unlock()
+	if c.items == nil {
+		c.items = make(map[string]*list.Element)
+		c.order = list.New()
+	}
+	if elem, ok := c.items[event]; ok {
+		elem.Value.(*matchEntry).keys = keys
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[event] = c.order.PushFront(&matchEntry{
+		event: event,
+		keys:  keys,
+	})
+	if c.order.Len() > size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*matchEntry).event)
+	}
+}
+
+func (c *matchCache) clear() {
+	c.Lock()
+	c.items = nil
+	c.order = nil
+	c.Unlock()
+}
+
+func (l *safeListeners) set(event string, callback eventCallback, once bool) ListenerID {
+	id := ListenerID(atomic.AddUint64(&lastListenerID, 1))
 	l.Lock()
-	listeners.list[event] = append(listeners.list[event], callback)
+	if l.list == nil {
+		l.list = make(map[string][]listener)
+	}
+	l.list[event] = append(l.list[event], listener{
+		id:       id,
+		callback: callback,
+		once:     once,
+	})
+	if isPattern(event) {
+		if l.patterns == nil {
+			l.patterns = make(map[string]int)
+		}
+		l.patterns[event]++
+		l.matches.clear()
+	}
 	l.Unlock()
+	return id
 }
 
+// Get the callbacks of the event and of the matching patterns,
+// the once listeners are removed so they are returned only one time
 func (l *safeListeners) get(event string) []eventCallback {
 	l.RLock()
-	defer l.RUnlock()
-	if _, ok := l.list[event]; !ok {
-		return make([]eventCallback, 0)
+	matched, hasOnce := l.collect(event, l.resolve(event))
+	l.RUnlock()
+	if !hasOnce {
+		return callbacksOf(matched)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	keys := l.resolve(event)
+	matched, hasOnce = l.collect(event, keys)
+	if hasOnce {
+		for _, key := range append([]string{event}, keys...) {
+			kept := make([]listener, 0, len(l.list[key]))
+			for _, v := range l.list[key] {
+				if !v.once {
+					kept = append(kept, v)
+				}
+			}
+			l.store(key, kept)
+		}
+	}
+	return callbacksOf(matched)
+}
+
+// Listeners of the event and of the given patterns in registration order,
+// must be called with the lock held
+func (l *safeListeners) collect(event string, patterns []string) ([]listener, bool) {
+	ret := make([]listener, 0, len(l.list[event]))
+	ret = append(ret, l.list[event]...)
+	for _, pattern := range patterns {
+		ret = append(ret, l.list[pattern]...)
+	}
+	if len(patterns) > 0 {
+		sort.Slice(ret, func(i, j int) bool {
+			return ret[i].id < ret[j].id
+		})
+	}
+
+	hasOnce := false
+	for _, v := range ret {
+		if v.once {
+			hasOnce = true
+			break
+		}
+	}
+	return ret, hasOnce
+}
+
+// Patterns matching the event, cached by event name.
+// Must be called with the lock held, for reading or writing
+func (l *safeListeners) resolve(event string) []string {
+	if len(l.patterns) == 0 {
+		return nil
+	}
+	if keys, ok := l.matches.get(event); ok {
+		return keys
+	}
+
+	keys := make([]string, 0)
+	for pattern := range l.patterns {
+		if pattern != event && matchEvent(pattern, event) {
+			keys = append(keys, pattern)
+		}
 	}
 
-	ret := make([]eventCallback, 0)
+	// event names may come from the clients,
+	// only the ones with listeners are cached
+	if len(keys) > 0 || len(l.list[event]) > 0 {
+		l.matches.add(event, keys, maxCachedMatches)
+	}
+	return keys
+}
+
+// Remove a listener of the event, returns false if not found
+func (l *safeListeners) remove(event string, id ListenerID) bool {
+	l.Lock()
+	defer l.Unlock()
+	kept := make([]listener, 0, len(l.list[event]))
 	for _, v := range l.list[event] {
-		ret = append(ret, v)
+		if v.id != id {
+			kept = append(kept, v)
+		}
+	}
+	found := len(kept) != len(l.list[event])
+	l.store(event, kept)
+	return found
+}
+
+// Remove all the listeners of the event
+func (l *safeListeners) removeAll(event string) {
+	l.Lock()
+	l.store(event, nil)
+	l.Unlock()
+}
+
+// Remove the listeners of all the events
+func (l *safeListeners) reset() {
+	l.Lock()
+	l.list = nil
+	l.patterns = nil
+	l.matches.clear()
+	l.Unlock()
+}
+
+// Must be called with the lock held
+func (l *safeListeners) store(event string, list []listener) {
+	if isPattern(event) && l.patterns[event] != len(list) {
+		if len(list) == 0 {
+			delete(l.patterns, event)
+		} else {
+			l.patterns[event] = len(list)
+		}
+		l.matches.clear()
+	}
+
+	if len(list) == 0 {
+		delete(l.list, event)
+		return
+	}
+	l.list[event] = list
+}
+
+func callbacksOf(list []listener) []eventCallback {
+	ret := make([]eventCallback, 0, len(list))
+	for _, v := range list {
+		ret = append(ret, v.callback)
 	}
 	return ret
 }
 
-// List of the listeners for the events
-var listeners = safeListeners{
-	list: make(map[string][]eventCallback),
-}
-
-func New(callback func(kws *Websocket)) func(*fiber.Ctx) error {
-	return websocket.New(func(c *websocket.Conn) {
-		kws := &Websocket{
-			Conn: c,
-			Locals: func(key string) interface{} {
-				return c.Locals(key)
-			},
-			Params: func(key string, defaultValue ...string) string {
-				return c.Params(key, defaultValue...)
-			},
-			Query: func(key string, defaultValue ...string) string {
-				return c.Query(key, defaultValue...)
-			},
-			Cookies: func(key string, defaultValue ...string) string {
-				return c.Cookies(key, defaultValue...)
-			},
-			queue:      make(chan message, 100),
-			done:       make(chan struct{}, 1),
-			attributes: make(map[string]interface{}),
-			isAlive:    true,
+// Split an event name in segments and separators
+func eventSegments(event string) []string {
+	return strings.FieldsFunc(event, func(r rune) bool {
+		return r == '.' || r == ':'
+	})
+}
+
+// Check if the event name contains wildcards
+func isPattern(event string) bool {
+	for _, segment := range eventSegments(event) {
+		if segment == "*" {
+			return true
 		}
+	}
+	return false
+}
+
+// Check if the pattern matches the event name,
+// the separators must be the same
+func matchEvent(pattern string, event string) bool {
+	if pattern == "*" {
+		return true
+	}
 
-		// Generate uuid
-		kws.UUID = kws.createUUID()
+	patternSegments := eventSegments(pattern)
+	segments := eventSegments(event)
+	if len(patternSegments) != len(segments) || separators(pattern) != separators(event) {
+		return false
+	}
 
-		// register the connection into the pool
-		pool.set(kws)
+	for i, segment := range patternSegments {
+		if segment != "*" && segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
 
-		// execute the callback of the socket initialization
-		callback(kws)
+// The separators of the event name in order
+func separators(event string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == ':' {
+			return r
+		}
+		return -1
+	}, event)
+}
 
-		kws.fireEvent(EventConnect, nil, nil)
+// List of the listeners for the events of the default hub
+var listeners = safeListeners{
+	list: make(map[string][]listener),
+}
 
-		// Run the loop for the given connection
-		kws.run()
-	})
+// New Create a websocket handler registered in the default hub
+func New(callback func(kws *Websocket), config ...Config) func(*fiber.Ctx) error {
+	return defaultHub.New(callback, config...)
 }
 
 func (kws *Websocket) GetUUID() string {
@@ -280,13 +678,26 @@ func (kws *Websocket) GetUUID() string {
 }
 
 func (kws *Websocket) SetUUID(uuid string) {
-	kws.mu.Lock()
-	defer kws.mu.Unlock()
-
-	if pool.contains(uuid) {
+	p := kws.getHub().pool
+	if p.contains(uuid) {
 		panic(ErrorUUIDDuplication)
 	}
+	kws.getHub().releaseParked(uuid)
+
+	kws.mu.Lock()
+	previous := kws.UUID
 	kws.UUID = uuid
+	kws.mu.Unlock()
+
+	// keep the pool and the rooms indexed by the new uuid
+	p.rename(previous, uuid)
+	kws.getHub().rooms.rename(previous, uuid)
+
+	// move the ownership in the cluster
+	if p.contains(uuid) {
+		kws.unregister(previous)
+		kws.register()
+	}
 }
 
 // SetAttribute Set a specific attribute for the specific socket connection
@@ -344,37 +755,27 @@ func (kws *Websocket) EmitToList(uuids []string, message []byte, mType ...int) {
 // EmitToList Emit the message to a specific socket uuids list
 // Ignores all errors
 func EmitToList(uuids []string, message []byte, mType ...int) {
-	for _, wsUUID := range uuids {
-		_ = EmitTo(wsUUID, message, mType...)
-	}
+	defaultHub.EmitToList(uuids, message, mType...)
 }
 
 // EmitTo Emit to a specific socket connection
 func (kws *Websocket) EmitTo(uuid string, message []byte, mType ...int) error {
-
-	if !pool.contains(uuid) || !pool.get(uuid).IsAlive() {
-		kws.fireEvent(EventError, []byte(uuid), ErrorInvalidConnection)
-		return ErrorInvalidConnection
+	err := kws.getHub().EmitTo(uuid, message, mType...)
+	if err != nil {
+		kws.fireEvent(EventError, []byte(uuid), err)
 	}
-
-	pool.get(uuid).Emit(message, mType...)
-	return nil
+	return err
 }
 
 // EmitTo Emit to a specific socket connection
 func EmitTo(uuid string, message []byte, mType ...int) error {
-	if !pool.contains(uuid) || !pool.get(uuid).IsAlive() {
-		return ErrorInvalidConnection
-	}
-
-	pool.get(uuid).Emit(message, mType...)
-	return nil
+	return defaultHub.EmitTo(uuid, message, mType...)
 }
 
 // Broadcast to all the active connections
 // except avoid broadcasting the message to itself
 func (kws *Websocket) Broadcast(message []byte, except bool, mType ...int) {
-	for wsUUID := range pool.all() {
+	for wsUUID := range kws.getHub().pool.all() {
 		if except && kws.UUID == wsUUID {
 			continue
 		}
@@ -383,13 +784,28 @@ func (kws *Websocket) Broadcast(message []byte, except bool, mType ...int) {
 			kws.fireEvent(EventError, message, err)
 		}
 	}
+
+	// kept for the replay when the clients reconnect
+	skip := ""
+	if except {
+		skip = kws.GetUUID()
+	}
+	kws.getHub().broadcastParked(message, skip, mType...)
+
+	// the connection is not in the other nodes
+	err := kws.getHub().publish(AdapterMessage{
+		Kind:  AdapterBroadcast,
+		Data:  message,
+		MType: messageType(mType),
+	})
+	if err != nil {
+		kws.fireEvent(EventError, message, err)
+	}
 }
 
 // Broadcast to all the active connections
 func Broadcast(message []byte, mType ...int) {
-	for _, kws := range pool.all() {
-		kws.Emit(message, mType...)
-	}
+	defaultHub.Broadcast(message, mType...)
 }
 
 // Fire custom event
@@ -399,22 +815,18 @@ func (kws *Websocket) Fire(event string, data []byte) {
 
 // Fire custom event on all connections
 func Fire(event string, data []byte) {
-	fireGlobalEvent(event, data, nil)
+	defaultHub.Fire(event, data)
 }
 
 // Emit /Write the message into the given connection
 func (kws *Websocket) Emit(message []byte, mType ...int) {
-	t := TextMessage
-	if len(mType) > 0 {
-		t = mType[0]
-	}
-	kws.write(t, message)
+	kws.write(messageType(mType), message)
 }
 
 // Close Actively close the connection from the server
+// with CloseNormalClosure, see CloseWithCode
 func (kws *Websocket) Close() {
-	kws.write(CloseMessage, []byte("Connection closed"))
-	kws.fireEvent(EventClose, nil, nil)
+	_ = kws.CloseWithCode(CloseNormalClosure, "")
 }
 
 func (kws *Websocket) IsAlive() bool {
@@ -441,27 +853,110 @@ func (kws *Websocket) queueLength() int {
 	return len(kws.queue)
 }
 
-// pong writes a control message to the client
-func (kws *Websocket) pong(ctx context.Context) {
-	timeoutTicker := time.NewTicker(PongTimeout)
+// heartbeat writes a ping control message to the client on every interval,
+// the read deadline set on every pong closes the dead connections
+func (kws *Websocket) heartbeat(ctx context.Context) {
+	timeoutTicker := time.NewTicker(kws.config.PingInterval)
 	defer timeoutTicker.Stop()
 	for {
 		select {
 		case <-timeoutTicker.C:
-			kws.write(PongMessage, []byte{})
+			if !kws.hasConn() {
+				continue
+			}
+			// control messages can be written concurrently with the send loop,
+			// a failed ping is detected by the read deadline
+			_ = kws.Conn.WriteControl(PingMessage, []byte{}, time.Now().Add(kws.config.PongDeadline))
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-// Add in message queue
+// Install the control message handlers and the first read deadline
+func (kws *Websocket) setupHeartbeat() {
+	if !kws.hasConn() {
+		return
+	}
+
+	_ = kws.Conn.SetReadDeadline(time.Now().Add(kws.config.PongDeadline))
+
+	kws.Conn.SetPongHandler(func(string) error {
+		kws.extendReadDeadline()
+		kws.fireEvent(EventPong, nil, nil)
+		return nil
+	})
+
+	kws.Conn.SetPingHandler(func(appData string) error {
+		kws.extendReadDeadline()
+		kws.fireEvent(EventPing, []byte(appData), nil)
+		err := kws.Conn.WriteControl(PongMessage, []byte(appData), time.Now().Add(kws.config.PongDeadline))
+		if isCloseSent(err) || isTimeout(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+// Check if the error is caused by an expired deadline
+func isTimeout(err error) bool {
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// The client is alive, wait for it until the next pong deadline
+func (kws *Websocket) extendReadDeadline() {
+	_ = kws.Conn.SetReadDeadline(time.Now().Add(kws.config.PongDeadline))
+}
+
+// Add in message queue, applying the overflow policy when the queue is full
 func (kws *Websocket) write(messageType int, messageBytes []byte) {
-	kws.queue <- message{
+	msg := message{
 		mType:   messageType,
 		data:    messageBytes,
 		retries: 0,
 	}
+
+	select {
+	case kws.queue <- msg:
+		return
+	default:
+	}
+
+	switch kws.config.OverflowPolicy {
+	case OverflowDropNewest:
+		kws.drop(msg)
+	case OverflowDropOldest:
+		for {
+			select {
+			case kws.queue <- msg:
+				return
+			case oldest := <-kws.queue:
+				kws.drop(oldest)
+			}
+		}
+	case OverflowDisconnect:
+		atomic.AddUint64(&kws.dropped, 1)
+		kws.disconnected(ErrorQueueFull)
+	default:
+		// stop waiting when the connection is gone
+		select {
+		case kws.queue <- msg:
+		case <-kws.done:
+		}
+	}
+}
+
+// Discard a message of the full queue
+func (kws *Websocket) drop(msg message) {
+	atomic.AddUint64(&kws.dropped, 1)
+	kws.fireEvent(EventError, msg.data, ErrorQueueFull)
+}
+
+// DroppedMessages Number of messages discarded
+// because the queue of the connection was full
+func (kws *Websocket) DroppedMessages() uint64 {
+	return atomic.LoadUint64(&kws.dropped)
 }
 
 // Send out message queue
@@ -470,22 +965,31 @@ func (kws *Websocket) send(ctx context.Context) {
 		select {
 		case message := <-kws.queue:
 			if !kws.hasConn() {
-				if message.retries <= MaxSendRetry {
+				if message.retries <= kws.config.MaxSendRetry {
 					// retry without blocking the sending thread
 					go func() {
-						time.Sleep(RetrySendTimeout)
+						time.Sleep(kws.config.RetrySendTimeout)
 						message.retries = message.retries + 1
-						kws.queue <- message
+						select {
+						case kws.queue <- message:
+						case <-kws.done:
+						}
 					}()
 				}
 				continue
 			}
 
-			kws.mu.RLock()
+			if kws.session != nil && isData(message) {
+				kws.session.record(message.mType, message.data)
+			}
+
+			if kws.config.WriteTimeout > 0 {
+				_ = kws.Conn.SetWriteDeadline(time.Now().Add(kws.config.WriteTimeout))
+			}
 			err := kws.Conn.WriteMessage(message.mType, message.data)
-			kws.mu.RUnlock()
 
-			if err != nil {
+			// the messages emitted after the close frame are discarded
+			if err != nil && !isCloseSent(err) {
 				kws.disconnected(err)
 			}
 		case <-ctx.Done():
@@ -500,68 +1004,116 @@ func (kws *Websocket) send(ctx context.Context) {
 func (kws *Websocket) run() {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 
-	go kws.pong(ctx)
-	go kws.read(ctx)
-	go kws.send(ctx)
+	wg := sync.WaitGroup{}
+
+	kws.setupHeartbeat()
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		kws.heartbeat(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		kws.read(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		kws.send(ctx)
+	}()
 
 	<-kws.done // block until one event is sent to the done channel
 
 	cancelFunc()
+
+	// unblock pending I/O, the connection is released
+	// as soon as run returns so every go routine must be stopped
+	if kws.hasConn() {
+		_ = kws.Conn.UnderlyingConn().Close()
+	}
+	wg.Wait()
+
+	kws.reliable.stop()
+
+	// keep the unsent messages for the replay
+	kws.drainSession()
+
+	// the listeners may still use the connection
+	kws.events.wait()
 }
 
 // Listen for incoming messages
 // and filter by message type
+//
+// The read blocks directly on the connection without holding the
+// connection mutex, run closes the connection to stop it
 func (kws *Websocket) read(ctx context.Context) {
-	timeoutTicker := time.NewTicker(ReadTimeout)
-	defer timeoutTicker.Stop()
+	if !kws.hasConn() {
+		return
+	}
+
 	for {
-		select {
-		case <-timeoutTicker.C:
-			if !kws.hasConn() {
-				continue
-			}
+		// control messages are handled by the ping/pong handlers
+		// while the read is pending
+		mType, msg, err := kws.Conn.ReadMessage()
 
-			kws.mu.RLock()
-			mType, msg, err := kws.Conn.ReadMessage()
-			kws.mu.RUnlock()
+		// the connection has been closed by run
+		if ctx.Err() != nil {
+			return
+		}
 
-			if mType == PingMessage {
-				kws.fireEvent(EventPing, nil, nil)
-				continue
-			}
+		if isTimeout(err) {
+			kws.disconnected(ErrorHeartbeatTimeout)
+			return
+		}
 
-			if mType == PongMessage {
-				kws.fireEvent(EventPong, nil, nil)
-				continue
-			}
+		if err != nil {
+			kws.disconnected(err)
+			return
+		}
 
-			if mType == CloseMessage {
-				kws.disconnected(nil)
-				return
-			}
+		kws.extendReadDeadline()
 
-			if err != nil {
-				kws.disconnected(err)
-				return
-			}
+		// replies to EmitWithAck and EmitReliable are not dispatched to the listeners
+		if mType == TextMessage && (kws.resolveAck(msg) || kws.resolveReliable(msg)) {
+			continue
+		}
 
-			// We have a message and we fire the message event
-			kws.fireEvent(EventMessage, msg, nil)
-		case <-ctx.Done():
-			return
+		payload := kws.decodeMessage(mType, msg)
+		if kws.duplicate(payload.ackID) {
+			continue
 		}
+
+		// We have a message and we fire the message event,
+		// once the listeners can keep up
+		kws.events.reserve()
+		kws.dispatch(payload)
 	}
 }
 
 // When the connection closes, disconnected method
 func (kws *Websocket) disconnected(err error) {
-	kws.fireEvent(EventDisconnect, nil, err)
+	// may be called multiple times from different go routines,
+	// only the first call handles the disconnection
+	kws.mu.Lock()
+	alive := kws.isAlive
+	kws.isAlive = false
+	kws.mu.Unlock()
+	if !alive {
+		return
+	}
 
-	// may be called multiple times from different go routines
-	if kws.IsAlive() {
-		close(kws.done)
+	// the rooms and the directory entry are kept by a parked session
+	parked := kws.parkSession(err)
+
+	payload := EventPayload{
+		Name:  EventDisconnect,
+		Error: err,
 	}
-	kws.setAlive(false)
+	payload.setCloseError(err)
+	kws.dispatch(payload)
+
+	close(kws.done)
 
 	// Fire error event if the connection is
 	// disconnected by an error
@@ -569,12 +1121,24 @@ func (kws *Websocket) disconnected(err error) {
 		kws.fireEvent(EventError, nil, err)
 	}
 
-	// Remove the socket from the pool
-	pool.delete(kws.UUID)
+	// Remove the socket from the pool, from its rooms and from the directory
+	kws.getHub().pool.delete(kws.UUID)
+	if !parked {
+		kws.leaveAll()
+		kws.unregister(kws.UUID)
+	}
+	kws.getHub().untrack(kws)
+
+	// Release the listeners of the connection,
+	// after the events still waiting to be handled
+	kws.events.close(kws.listeners.reset)
 }
 
 // Create random UUID for each connection
 func (kws *Websocket) createUUID() string {
+	if kws.config.UUIDGenerator != nil {
+		return kws.config.UUIDGenerator()
+	}
 	return kws.randomUUID()
 }
 
@@ -583,33 +1147,119 @@ func (kws *Websocket) randomUUID() string {
 	return uuid.New().String()
 }
 
-// Fires event on all connections.
-func fireGlobalEvent(event string, data []byte, error error) {
-	for _, kws := range pool.all() {
-		kws.fireEvent(event, data, error)
+// The hub the connection is registered in,
+// falls back to the default hub
+func (kws *Websocket) getHub() *Hub {
+	if kws.hub == nil {
+		return defaultHub
 	}
+	return kws.hub
 }
 
 // Checks if there is at least a listener for a given event
 // and loop over the callbacks registered
 func (kws *Websocket) fireEvent(event string, data []byte, error error) {
-	callbacks := listeners.get(event)
+	kws.dispatch(EventPayload{
+		Name:  event,
+		Data:  data,
+		Error: error,
+	})
+}
+
+// Schedule the payload according to the Dispatch of the connection
+func (kws *Websocket) dispatch(payload EventPayload) {
+	payload.Kws = kws
+	payload.SocketUUID = kws.GetUUID()
+
+	// the listeners may run concurrently with SetAttribute
+	kws.mu.RLock()
+	payload.SocketAttributes = make(map[string]interface{}, len(kws.attributes))
+	for key, value := range kws.attributes {
+		payload.SocketAttributes[key] = value
+	}
+	kws.mu.RUnlock()
+
+	kws.events.schedule(func() {
+		kws.handle(payload)
+	})
+}
+
+// Run the payload through the middlewares of the hub and then
+// to the listeners, an error of the pipeline is fired as EventError
+func (kws *Websocket) handle(payload EventPayload) {
+	handler := kws.getHub().pipeline(payload.Name, kws.deliver)
+
+	var err error
+	if recovered := protect(payload.Name, func() {
+		err = handler(&payload)
+	}); recovered != nil {
+		kws.panicked(&payload, recovered)
+		return
+	}
+
+	// errors handling EventError are not fired again to avoid loops
+	if err != nil && payload.Name != EventError {
+		kws.fireEvent(EventError, payload.Data, err)
+	}
+}
+
+// Loop over the callbacks registered for the payload event,
+// every callback receives its own copy of the payload
+func (kws *Websocket) deliver(payload *EventPayload) error {
+	// global listeners first, then the ones of the connection
+	callbacks := append(kws.getHub().listeners.get(payload.Name), kws.listeners.get(payload.Name)...)
 
 	for _, callback := range callbacks {
-		callback(&EventPayload{
-			Kws:              kws,
-			Name:             event,
-			SocketUUID:       kws.UUID,
-			SocketAttributes: kws.attributes,
-			Data:             data,
-			Error:            error,
-		})
+		p := *payload
+		// a panic stops only the callback, the next ones are still called
+		if recovered := protect(payload.Name, func() {
+			callback(&p)
+		}); recovered != nil {
+			kws.panicked(payload, recovered)
+		}
 	}
+	return nil
 }
 
 type eventCallback func(payload *EventPayload)
 
-// On Add listener callback for an event into the listeners list
-func On(event string, callback eventCallback) {
-	listeners.set(event, callback)
+// On Add listener callback for an event of this connection only,
+// the listeners are released on disconnection
+func (kws *Websocket) On(event string, callback eventCallback) ListenerID {
+	return kws.listeners.set(event, callback, false)
+}
+
+// Once Add listener callback for an event of this connection only,
+// removed after the first call
+func (kws *Websocket) Once(event string, callback eventCallback) ListenerID {
+	return kws.listeners.set(event, callback, true)
+}
+
+// Off Remove a listener of the connection,
+// returns false if the listener was not registered
+func (kws *Websocket) Off(event string, id ListenerID) bool {
+	return kws.listeners.remove(event, id)
+}
+
+// On Add listener callback for an event into the listeners list,
+// the returned id removes it with Off. The event can be a pattern as
+// "chat.*" or "room:*:message", "*" listens to every event.
+func On(event string, callback eventCallback) ListenerID {
+	return defaultHub.On(event, callback)
+}
+
+// Once Add listener callback for an event, removed after the first call
+func Once(event string, callback eventCallback) ListenerID {
+	return defaultHub.Once(event, callback)
+}
+
+// Off Remove the listener of an event,
+// returns false if the listener was not registered
+func Off(event string, id ListenerID) bool {
+	return defaultHub.Off(event, id)
+}
+
+// RemoveAllListeners Remove all the listeners of an event
+func RemoveAllListeners(event string) {
+	defaultHub.RemoveAllListeners(event)
 }
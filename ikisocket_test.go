@@ -3,6 +3,8 @@ package ikisocket
 import (
 	"context"
 	"net"
+	"runtime"
+	"runtime/metrics"
 	"strconv"
 	"sync"
 	"testing"
@@ -112,11 +114,12 @@ func TestParallelConnections(t *testing.T) {
 	app.Use(upgradeMiddleware)
 
 	// send back response on correct message
-	On(EventMessage, func(payload *EventPayload) {
+	id := On(EventMessage, func(payload *EventPayload) {
 		if string(payload.Data) == "test" {
 			payload.Kws.Emit([]byte("response"))
 		}
 	})
+	defer Off(EventMessage, id)
 
 	// create websocket endpoint
 	app.Get("/", New(func(kws *Websocket) {
@@ -186,7 +189,8 @@ func TestGlobalFire(t *testing.T) {
 	h.wg.Add(numTestConn)
 
 	// register custom event handler
-	On("customevent", h.OnCustomEvent)
+	id := On("customevent", h.OnCustomEvent)
+	defer Off("customevent", id)
 
 	// fire global custom event on all connections
 	Fire("customevent", []byte("test"))
@@ -326,7 +330,53 @@ func assertPanic(t *testing.T, f func()) {
 	f()
 }
 
-func createWS() *Websocket {
+// Option of the connections created by createWS
+type wsOption func(kws *Websocket)
+
+// Add the connection to the pool of the hub
+func withHub(h *Hub) wsOption {
+	return func(kws *Websocket) {
+		kws.hub = h
+	}
+}
+
+// Create the done channel closed on disconnection
+func withDone() wsOption {
+	return func(kws *Websocket) {
+		kws.done = make(chan struct{}, 1)
+	}
+}
+
+// Buffer the queue of the messages
+func withQueue(size int) wsOption {
+	return func(kws *Websocket) {
+		kws.queue = make(chan message, size)
+	}
+}
+
+// Settings of the connection, not completed with the defaults
+func withConfig(config Config) wsOption {
+	return func(kws *Websocket) {
+		kws.config = config
+	}
+}
+
+// Replace the generated uuid
+func withUUID(uuid string) wsOption {
+	return func(kws *Websocket) {
+		kws.UUID = uuid
+	}
+}
+
+// Schedule the events with the mode and the worker pool
+func withDispatch(mode DispatchMode, workers *workerPool) wsOption {
+	return func(kws *Websocket) {
+		kws.events.mode = mode
+		kws.events.workers = workers
+	}
+}
+
+func createWS(options ...wsOption) *Websocket {
 	kws := &Websocket{
 		Conn: nil,
 		Locals: func(key string) interface{} {
@@ -348,6 +398,13 @@ func createWS() *Websocket {
 
 	kws.UUID = kws.createUUID()
 
+	for _, option := range options {
+		option(kws)
+	}
+	if kws.hub != nil {
+		kws.hub.pool.set(kws)
+	}
+
 	return kws
 }
 
@@ -393,7 +450,7 @@ func (s *WebsocketMock) Close() {
 	panic("implement me")
 }
 
-func (s *WebsocketMock) pong(_ context.Context) {
+func (s *WebsocketMock) heartbeat(_ context.Context) {
 	panic("implement me")
 }
 
@@ -424,3 +481,189 @@ func (s *WebsocketMock) randomUUID() string {
 func (s *WebsocketMock) fireEvent(_ string, _ []byte, _ error) {
 	panic("implement me")
 }
+
+func TestHeartbeatTimeout(t *testing.T) {
+	h := NewHub(Config{
+		PingInterval: 20 * time.Millisecond,
+		PongDeadline: 100 * time.Millisecond,
+	})
+
+	disconnected := make(chan error, 1)
+	h.On(EventDisconnect, func(payload *EventPayload) {
+		disconnected <- payload.Error
+	})
+
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {}))
+	defer shutdown()
+
+	// the client never reads, so the pings are never answered
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	select {
+	case err := <-disconnected:
+		require.Equal(t, ErrorHeartbeatTimeout, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("dead connection not detected")
+	}
+	require.Empty(t, h.pool.all())
+}
+
+func TestHeartbeatAlive(t *testing.T) {
+	h := NewHub(Config{
+		PingInterval: 20 * time.Millisecond,
+		PongDeadline: 100 * time.Millisecond,
+	})
+
+	pongs := make(chan struct{}, 100)
+	h.On(EventPong, func(payload *EventPayload) {
+		pongs <- struct{}{}
+	})
+
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {}))
+	defer shutdown()
+
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// reading makes the client answer to the pings
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+
+	require.NotEmpty(t, pongs)
+	require.Len(t, h.pool.all(), 1)
+}
+
+func TestReadDoesNotBlockWriters(t *testing.T) {
+	h := NewHub()
+
+	connected := make(chan *Websocket, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		connected <- kws
+	}))
+	defer shutdown()
+
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	kws := <-connected
+
+	// the read loop is blocked waiting for a message
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		kws.SetAttribute("key", "value")
+		kws.SetUUID("new-uuid")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writers are blocked by the pending read")
+	}
+	require.Equal(t, "value", kws.GetStringAttribute("key"))
+}
+
+// Per message round trip with numParallelTestConn open connections,
+// reports the CPU time spent by the process for every message
+func BenchmarkReadLoop(b *testing.B) {
+	h := NewHub()
+	h.On(EventMessage, func(payload *EventPayload) {
+		payload.Kws.Emit(payload.Data)
+	})
+
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {}))
+	defer shutdown()
+
+	conns := make([]*websocket.Conn, numParallelTestConn)
+	for i := range conns {
+		dialer := &websocket.Dialer{
+			NetDial: func(network, addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+			HandshakeTimeout: 45 * time.Second,
+		}
+		conn, _, err := dialer.Dial("ws://"+ln.Addr().String(), nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		conns[i] = conn
+	}
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	// the cpu metrics are refreshed by the garbage collector
+	cpu := []metrics.Sample{{Name: "/cpu/classes/total:cpu-seconds"}}
+	runtime.GC()
+	metrics.Read(cpu)
+	start := cpu[0].Value
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn := conns[i%len(conns)]
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("test")); err != nil {
+			b.Fatal(err)
+		}
+		if _, _, err := conn.ReadMessage(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	runtime.GC()
+	metrics.Read(cpu)
+	if start.Kind() == metrics.KindFloat64 && cpu[0].Value.Kind() == metrics.KindFloat64 {
+		spent := cpu[0].Value.Float64() - start.Float64()
+		b.ReportMetric(spent*float64(time.Second)/float64(b.N), "cpu-ns/op")
+	}
+}
+
+// Start a test server on an in memory listener
+func startTestServer(handler func(*fiber.Ctx) error) (*fasthttputil.InmemoryListener, func()) {
+	app := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+	})
+	ln := fasthttputil.NewInmemoryListener()
+
+	app.Use(upgradeMiddleware)
+	app.Get("/", handler)
+
+	go func() {
+		_ = app.Listener(ln)
+	}()
+
+	return ln, func() {
+		_ = app.Shutdown()
+		_ = ln.Close()
+	}
+}
+
+func dialTestServer(t *testing.T, ln *fasthttputil.InmemoryListener) *websocket.Conn {
+	dialer := &websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		HandshakeTimeout: 45 * time.Second,
+	}
+	conn, _, err := dialer.Dial("ws://"+ln.Addr().String(), nil)
+	require.Nil(t, err)
+	return conn
+}
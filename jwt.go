@@ -0,0 +1,174 @@
+package ikisocket
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// ErrorInvalidToken The JWT of the request is missing, malformed,
+	// signed with another algorithm or key, or not valid yet
+	ErrorInvalidToken = errors.New("token is missing or not valid")
+	// ErrorTokenExpired The exp claim of the JWT is in the past
+	ErrorTokenExpired = errors.New("token is expired")
+	// ErrorInvalidKey The key of a JWT verifier is missing
+	ErrorInvalidKey = errors.New("verification key is missing")
+)
+
+// JWTConfig defines the config of the JWT verifiers
+type JWTConfig struct {
+	// Query Name of the query parameter holding the token when the request
+	// has no "Authorization: Bearer <token>" header. Browsers cannot set
+	// the headers of a websocket request.
+	//
+	// Optional. Default: "token"
+	Query string
+
+	// UserClaim Claim holding the user id of the identity
+	//
+	// Optional. Default: "sub"
+	UserClaim string
+
+	// Leeway Clock skew tolerated on the exp and nbf claims
+	//
+	// Optional. Default: 0
+	Leeway time.Duration
+}
+
+// JWTConfigDefault is the default config of the JWT verifiers
+var JWTConfigDefault = JWTConfig{
+	Query:     "token",
+	UserClaim: "sub",
+}
+
+// Helper function to set default values
+func jwtConfigDefault(config ...JWTConfig) JWTConfig {
+	if len(config) < 1 {
+		return JWTConfigDefault
+	}
+
+	cfg := config[0]
+	if cfg.Query == "" {
+		cfg.Query = JWTConfigDefault.Query
+	}
+	if cfg.UserClaim == "" {
+		cfg.UserClaim = JWTConfigDefault.UserClaim
+	}
+	return cfg
+}
+
+// JWTHS256 Authenticate verifying a JWT signed with HMAC SHA-256
+// and the secret, the identity has the claims of the token.
+// Panics if the secret is empty.
+func JWTHS256(secret []byte, config ...JWTConfig) func(c *fiber.Ctx) (Identity, error) {
+	if len(secret) == 0 {
+		panic(ErrorInvalidKey)
+	}
+	return jwtVerifier("HS256", jwtConfigDefault(config...), func(signed, signature []byte) bool {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(signature, mac.Sum(nil))
+	})
+}
+
+// JWTRS256 Authenticate verifying a JWT signed with RSA SHA-256
+// and the public key, the identity has the claims of the token.
+// Panics if the key is nil.
+func JWTRS256(key *rsa.PublicKey, config ...JWTConfig) func(c *fiber.Ctx) (Identity, error) {
+	if key == nil || key.N == nil {
+		panic(ErrorInvalidKey)
+	}
+	return jwtVerifier("RS256", jwtConfigDefault(config...), func(signed, signature []byte) bool {
+		hash := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	})
+}
+
+func jwtVerifier(alg string, cfg JWTConfig, verify func(signed, signature []byte) bool) func(c *fiber.Ctx) (Identity, error) {
+	return func(c *fiber.Ctx) (Identity, error) {
+		token := bearerToken(c.Get(fiber.HeaderAuthorization))
+		if token == "" {
+			token = c.Query(cfg.Query)
+		}
+
+		claims, err := parseJWT(token, alg, cfg.Leeway, verify)
+		if err != nil {
+			return Identity{}, err
+		}
+
+		userID, _ := claims[cfg.UserClaim].(string)
+		return Identity{
+			UserID: userID,
+			Claims: claims,
+		}, nil
+	}
+}
+
+// The token of a "Bearer <token>" header, empty for the other schemes
+func bearerToken(header string) string {
+	const prefix = "bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// Verify the signature and the time claims of the token, returns its claims
+func parseJWT(token string, alg string, leeway time.Duration, verify func(signed, signature []byte) bool) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrorInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Alg != alg {
+		return nil, ErrorInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrorInvalidToken
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, ErrorInvalidToken
+	}
+
+	now := time.Now()
+	if exp, ok := claims["exp"]; ok {
+		seconds, ok := exp.(float64)
+		if !ok {
+			return nil, ErrorInvalidToken
+		}
+		if now.After(time.Unix(int64(seconds), 0).Add(leeway)) {
+			return nil, ErrorTokenExpired
+		}
+	}
+	if nbf, ok := claims["nbf"]; ok {
+		seconds, ok := nbf.(float64)
+		if !ok || now.Before(time.Unix(int64(seconds), 0).Add(-leeway)) {
+			return nil, ErrorInvalidToken
+		}
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
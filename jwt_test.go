@@ -0,0 +1,140 @@
+package ikisocket
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+)
+
+func signJWT(t *testing.T, alg string, claims map[string]interface{}, sign func(signed []byte) []byte) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	require.Nil(t, err)
+	payload, err := json.Marshal(claims)
+	require.Nil(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func signHS256(t *testing.T, secret []byte, claims map[string]interface{}) string {
+	return signJWT(t, "HS256", claims, func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	})
+}
+
+// Run the verifier on a request with the authorization header and the uri
+func verifyJWT(verifier func(c *fiber.Ctx) (Identity, error), authorization string, uri string) (Identity, error) {
+	app := fiber.New()
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.SetRequestURI(uri)
+	if authorization != "" {
+		fctx.Request.Header.Set(fiber.HeaderAuthorization, authorization)
+	}
+	c := app.AcquireCtx(fctx)
+	defer app.ReleaseCtx(c)
+	return verifier(c)
+}
+
+func TestJWTHS256(t *testing.T) {
+	secret := []byte("secret")
+	verifier := JWTHS256(secret)
+
+	token := signHS256(t, secret, map[string]interface{}{
+		"sub":  "alice",
+		"role": "admin",
+		"exp":  time.Now().Add(time.Minute).Unix(),
+	})
+
+	identity, err := verifyJWT(verifier, "Bearer "+token, "/")
+	require.Nil(t, err)
+	require.Equal(t, "alice", identity.UserID)
+	require.Equal(t, "admin", identity.Claims["role"])
+
+	identity, err = verifyJWT(verifier, "", "/?token="+token)
+	require.Nil(t, err)
+	require.Equal(t, "alice", identity.UserID)
+
+	_, err = verifyJWT(verifier, "", "/")
+	require.Equal(t, ErrorInvalidToken, err)
+
+	_, err = verifyJWT(verifier, "Basic "+token, "/")
+	require.Equal(t, ErrorInvalidToken, err)
+
+	// signed with another secret
+	_, err = verifyJWT(verifier, "Bearer "+signHS256(t, []byte("other"), map[string]interface{}{"sub": "alice"}), "/")
+	require.Equal(t, ErrorInvalidToken, err)
+
+	// the algorithm of the header must match the verifier
+	unsigned := signJWT(t, "none", map[string]interface{}{"sub": "alice"}, func(signed []byte) []byte {
+		return nil
+	})
+	_, err = verifyJWT(verifier, "Bearer "+unsigned, "/")
+	require.Equal(t, ErrorInvalidToken, err)
+
+	expired := signHS256(t, secret, map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()})
+	_, err = verifyJWT(verifier, "Bearer "+expired, "/")
+	require.Equal(t, ErrorTokenExpired, err)
+
+	_, err = verifyJWT(JWTHS256(secret, JWTConfig{Leeway: 2 * time.Minute}), "Bearer "+expired, "/")
+	require.Nil(t, err)
+
+	notBefore := signHS256(t, secret, map[string]interface{}{"nbf": time.Now().Add(time.Minute).Unix()})
+	_, err = verifyJWT(verifier, "Bearer "+notBefore, "/")
+	require.Equal(t, ErrorInvalidToken, err)
+}
+
+func TestJWTRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+
+	sign := func(key *rsa.PrivateKey) func(signed []byte) []byte {
+		return func(signed []byte) []byte {
+			hash := sha256.Sum256(signed)
+			signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+			require.Nil(t, err)
+			return signature
+		}
+	}
+	verifier := JWTRS256(&key.PublicKey, JWTConfig{
+		Query:     "access_token",
+		UserClaim: "user",
+	})
+
+	token := signJWT(t, "RS256", map[string]interface{}{"user": "alice"}, sign(key))
+	identity, err := verifyJWT(verifier, "", "/?access_token="+token)
+	require.Nil(t, err)
+	require.Equal(t, "alice", identity.UserID)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	_, err = verifyJWT(verifier, "Bearer "+signJWT(t, "RS256", map[string]interface{}{"user": "alice"}, sign(other)), "/")
+	require.Equal(t, ErrorInvalidToken, err)
+
+	// a HS256 token signed with the public key is rejected
+	_, err = verifyJWT(verifier, "Bearer "+signHS256(t, key.PublicKey.N.Bytes(), map[string]interface{}{"user": "alice"}), "/")
+	require.Equal(t, ErrorInvalidToken, err)
+}
+
+func TestJWTInvalidKey(t *testing.T) {
+	assertPanic(t, func() {
+		JWTRS256(nil)
+	})
+	assertPanic(t, func() {
+		JWTRS256(&rsa.PublicKey{})
+	})
+	assertPanic(t, func() {
+		JWTHS256(nil)
+	})
+}
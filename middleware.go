@@ -0,0 +1,66 @@
+package ikisocket
+
+// Handler Step of the event pipeline, a returned error is fired
+// on the connection as EventError with the payload data
+type Handler func(payload *EventPayload) error
+
+// Middleware Wrap the next handler of the event pipeline.
+// A middleware can short-circuit the event by not calling next,
+// mutate the payload before calling it or return an error.
+type Middleware func(next Handler) Handler
+
+// Middleware added for a single event or pattern
+type eventMiddleware struct {
+	event      string
+	middleware Middleware
+}
+
+// Use Add middlewares running before the listeners of every event of the hub,
+// in the order they are added
+func (h *Hub) Use(middlewares ...Middleware) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.middlewares = append(h.middlewares, middlewares...)
+}
+
+// UseEvent Add middlewares running before the listeners of an event only,
+// after the ones added with Use. The event can be a pattern as in On.
+func (h *Hub) UseEvent(event string, middlewares ...Middleware) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, middleware := range middlewares {
+		h.eventMiddlewares = append(h.eventMiddlewares, eventMiddleware{
+			event:      event,
+			middleware: middleware,
+		})
+	}
+}
+
+// Wrap the final handler with the middlewares of the event,
+// the first middleware added is the outermost one
+func (h *Hub) pipeline(event string, final Handler) Handler {
+	h.mu.RLock()
+	chain := append([]Middleware(nil), h.middlewares...)
+	for _, m := range h.eventMiddlewares {
+		if m.event == event || (isPattern(m.event) && matchEvent(m.event, event)) {
+			chain = append(chain, m.middleware)
+		}
+	}
+	h.mu.RUnlock()
+
+	handler := final
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](handler)
+	}
+	return handler
+}
+
+// Use Add middlewares running before the listeners of every event
+func Use(middlewares ...Middleware) {
+	defaultHub.Use(middlewares...)
+}
+
+// UseEvent Add middlewares running before the listeners of an event only
+func UseEvent(event string, middlewares ...Middleware) {
+	defaultHub.UseEvent(event, middlewares...)
+}
@@ -0,0 +1,115 @@
+package ikisocket
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHubUse(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h))
+
+	var calls []string
+	h.Use(func(next Handler) Handler {
+		return func(payload *EventPayload) error {
+			calls = append(calls, "first "+payload.Name)
+			return next(payload)
+		}
+	}, func(next Handler) Handler {
+		return func(payload *EventPayload) error {
+			calls = append(calls, "second "+payload.Name)
+			payload.Data = append([]byte("mutated "), payload.Data...)
+			return next(payload)
+		}
+	})
+	h.UseEvent("chat.*", func(next Handler) Handler {
+		return func(payload *EventPayload) error {
+			calls = append(calls, "event "+payload.Name)
+			return next(payload)
+		}
+	})
+	h.On("chat.message", func(payload *EventPayload) {
+		calls = append(calls, "listener "+string(payload.Data))
+	})
+
+	kws.Fire("chat.message", []byte("data"))
+	require.Equal(t, []string{
+		"first chat.message",
+		"second chat.message",
+		"event chat.message",
+		"listener mutated data",
+	}, calls)
+
+	// the event middlewares run only for the matching events
+	calls = nil
+	kws.Fire("other", nil)
+	require.Equal(t, []string{"first other", "second other"}, calls)
+}
+
+func TestHubUseShortCircuit(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h))
+
+	h.UseEvent("private", func(next Handler) Handler {
+		return func(payload *EventPayload) error {
+			if payload.SocketAttributes["admin"] != true {
+				return nil
+			}
+			return next(payload)
+		}
+	})
+
+	calls := 0
+	h.On("private", func(payload *EventPayload) {
+		calls++
+	})
+	kws.Fire("private", nil)
+	require.Equal(t, 0, calls)
+
+	kws.SetAttribute("admin", true)
+	kws.Fire("private", nil)
+	require.Equal(t, 1, calls)
+}
+
+func TestHubUseError(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h))
+
+	invalid := errors.New("invalid payload")
+	h.Use(func(next Handler) Handler {
+		return func(payload *EventPayload) error {
+			if payload.Name == EventMessage {
+				return invalid
+			}
+			return next(payload)
+		}
+	})
+
+	// errors of the EventError pipeline are not fired again
+	h.UseEvent(EventError, func(next Handler) Handler {
+		return func(payload *EventPayload) error {
+			if err := next(payload); err != nil {
+				return err
+			}
+			return invalid
+		}
+	})
+
+	messages := 0
+	h.On(EventMessage, func(payload *EventPayload) {
+		messages++
+	})
+	var errs []error
+	var data []string
+	h.On(EventError, func(payload *EventPayload) {
+		errs = append(errs, payload.Error)
+		data = append(data, string(payload.Data))
+	})
+
+	kws.fireEvent(EventMessage, []byte("message"), nil)
+	require.Equal(t, 0, messages)
+	require.Equal(t, []error{invalid}, errs)
+	require.Equal(t, []string{"message"}, data)
+}
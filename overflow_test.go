@@ -0,0 +1,77 @@
+package ikisocket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverflowDropNewest(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h), withConfig(Config{OverflowPolicy: OverflowDropNewest}), withQueue(1), withDone())
+
+	errs := new(HandlerMock)
+	errs.On("OnCustomEvent", mock.Anything).Return(nil)
+	h.On(EventError, errs.OnCustomEvent)
+
+	errs.wg.Add(1)
+	kws.Emit([]byte("first"))
+	kws.Emit([]byte("second"))
+	errs.wg.Wait()
+
+	require.Equal(t, uint64(1), kws.DroppedMessages())
+	payload := errs.Calls[0].Arguments.Get(0).(*EventPayload)
+	require.Equal(t, ErrorQueueFull, payload.Error)
+	require.Equal(t, "second", string(payload.Data))
+	require.Equal(t, "first", string((<-kws.queue).data))
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h), withConfig(Config{OverflowPolicy: OverflowDropOldest}), withQueue(1), withDone())
+
+	kws.Emit([]byte("first"))
+	kws.Emit([]byte("second"))
+	kws.Emit([]byte("third"))
+
+	require.Equal(t, uint64(2), kws.DroppedMessages())
+	require.Equal(t, "third", string((<-kws.queue).data))
+}
+
+func TestOverflowDisconnect(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h), withConfig(Config{OverflowPolicy: OverflowDisconnect}), withQueue(1), withDone())
+
+	disconnected := new(HandlerMock)
+	disconnected.On("OnCustomEvent", mock.Anything).Return(nil)
+	h.On(EventDisconnect, disconnected.OnCustomEvent)
+
+	disconnected.wg.Add(1)
+	kws.Emit([]byte("first"))
+	kws.Emit([]byte("second"))
+	disconnected.wg.Wait()
+
+	require.False(t, kws.IsAlive())
+	require.False(t, h.pool.contains(kws.UUID))
+	payload := disconnected.Calls[0].Arguments.Get(0).(*EventPayload)
+	require.Equal(t, ErrorQueueFull, payload.Error)
+}
+
+func TestOverflowBlockStopsOnDisconnect(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h), withConfig(Config{OverflowPolicy: OverflowBlock}), withQueue(1), withDone())
+
+	kws.Emit([]byte("first"))
+
+	done := make(chan struct{})
+	go func() {
+		kws.Emit([]byte("second"))
+		close(done)
+	}()
+
+	kws.disconnected(nil)
+	<-done
+
+	require.Equal(t, uint64(0), kws.DroppedMessages())
+}
@@ -0,0 +1,60 @@
+package ikisocket
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError Panic recovered in a callback or middleware of an event,
+// fired as error of EventError
+type PanicError struct {
+	// Event Name of the event handled when the panic happened
+	Event string
+	// Value Value passed to panic
+	Value interface{}
+	// Stack Stack trace of the panicking goroutine
+	Stack []byte
+}
+
+// Error Describe the panic and the event that caused it
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic handling event %s: %v", e.Event, e.Value)
+}
+
+// Unwrap The panic value, when it is an error
+func (e *PanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Call the function recovering a panic into a PanicError
+func protect(event string, fn func()) (err *PanicError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{
+				Event: event,
+				Value: r,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
+
+	fn()
+	return nil
+}
+
+// Report a recovered panic as EventError,
+// disconnecting the connection if configured
+func (kws *Websocket) panicked(payload *EventPayload, err *PanicError) {
+	if kws.config.DisconnectOnPanic {
+		kws.disconnected(err)
+		return
+	}
+
+	// a panic handling EventError is not fired again to avoid loops
+	if payload.Name != EventError {
+		kws.fireEvent(EventError, payload.Data, err)
+	}
+}
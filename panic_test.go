@@ -0,0 +1,89 @@
+package ikisocket
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h))
+
+	kws.SetAttribute("count", "not an int")
+
+	calls := 0
+	h.On("event", func(payload *EventPayload) {
+		payload.Kws.GetIntAttribute("count")
+	})
+	h.On("event", func(payload *EventPayload) {
+		calls++
+	})
+
+	var errs []error
+	h.On(EventError, func(payload *EventPayload) {
+		errs = append(errs, payload.Error)
+		require.Equal(t, "data", string(payload.Data))
+		// a panic of the error listeners is not fired again
+		panic("error listener")
+	})
+
+	require.NotPanics(t, func() {
+		kws.Fire("event", []byte("data"))
+	})
+	require.Equal(t, 1, calls)
+	require.Len(t, errs, 1)
+
+	var panicErr *PanicError
+	require.True(t, errors.As(errs[0], &panicErr))
+	require.Equal(t, "event", panicErr.Event)
+	require.NotEmpty(t, panicErr.Stack)
+	require.True(t, kws.IsAlive())
+}
+
+func TestPanicRecoveryMiddleware(t *testing.T) {
+	h := NewHub()
+	kws := createWS(withHub(h))
+
+	cause := errors.New("middleware failure")
+	h.UseEvent("event", func(next Handler) Handler {
+		return func(payload *EventPayload) error {
+			panic(cause)
+		}
+	})
+
+	var errs []error
+	h.On(EventError, func(payload *EventPayload) {
+		errs = append(errs, payload.Error)
+	})
+
+	kws.Fire("event", nil)
+	require.Len(t, errs, 1)
+	require.True(t, errors.Is(errs[0], cause))
+}
+
+func TestDisconnectOnPanic(t *testing.T) {
+	h := NewHub(Config{DisconnectOnPanic: true})
+	kws := createWS(withHub(h), withConfig(configDefault(h.config)), withDone())
+
+	h.On("event", func(payload *EventPayload) {
+		panic("listener")
+	})
+	var disconnectErr error
+	h.On(EventDisconnect, func(payload *EventPayload) {
+		disconnectErr = payload.Error
+	})
+	errs := 0
+	h.On(EventError, func(payload *EventPayload) {
+		errs++
+	})
+
+	kws.Fire("event", nil)
+
+	var panicErr *PanicError
+	require.True(t, errors.As(disconnectErr, &panicErr))
+	require.Equal(t, 1, errs)
+	require.False(t, kws.IsAlive())
+	require.False(t, h.pool.contains(kws.UUID))
+}
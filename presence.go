@@ -0,0 +1,467 @@
+package ikisocket
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// PresenceTTL Lease of the users of the other nodes, every node sends
+// its users again every third of the ttl
+const PresenceTTL = 30 * time.Second
+
+// Presence Online users of a hub. A user is online while at least one
+// of its connections is tracked, on this node or, with an adapter, on
+// the other nodes of the cluster.
+//
+// EventOnline and EventOffline are fired with the user id as data when
+// the first connection of a user is tracked and when the last one is
+// disconnected. The users going online or offline on the other nodes
+// are passed to the OnChange callbacks instead, there is no connection
+// to fire them on.
+//
+// A node asks the others for their users when it joins the cluster and
+// then publishes the changes. The users of the other nodes are leases of
+// PresenceTTL, refreshed by the heartbeat of their node: the users of a
+// crashed node go offline when its lease expires.
+type Presence struct {
+	sync.RWMutex
+	hub *Hub
+	// Local connections of every user
+	local map[string]map[*Websocket]struct{}
+	// Users of the other nodes with their rooms, by node
+	remote map[string]map[string][]string
+	// Expiration of the users of the other nodes, by node
+	expires map[string]time.Time
+	// Lease of the users of the other nodes
+	ttl time.Duration
+	// Stops the heartbeat, nil when it is not running
+	stop chan struct{}
+	// Callbacks of the changes coming from the other nodes
+	callbacks []func(userID string, online bool)
+}
+
+func newPresence(h *Hub) *Presence {
+	return &Presence{
+		hub:     h,
+		local:   make(map[string]map[*Websocket]struct{}),
+		remote:  make(map[string]map[string][]string),
+		expires: make(map[string]time.Time),
+		ttl:     PresenceTTL,
+	}
+}
+
+// Presence The online users of the hub
+func (h *Hub) Presence() *Presence {
+	h.mu.Lock()
+	if h.presence != nil {
+		defer h.mu.Unlock()
+		return h.presence
+	}
+	p := newPresence(h)
+	h.presence = p
+	h.mu.Unlock()
+
+	p.join()
+	return p
+}
+
+// The presence of the hub, nil if it is not used
+func (h *Hub) getPresence() *Presence {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.presence
+}
+
+// Track Associate the connection to the user until its disconnection,
+// an empty user id removes the association. Same as kws.SetUserID.
+func (p *Presence) Track(kws *Websocket, userID string) {
+	kws.SetUserID(userID)
+}
+
+// Online Returns true if the user has at least one connection
+func (p *Presence) Online(userID string) bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.online(userID)
+}
+
+// List The online users with a connection in the room,
+// all the online users when the room is empty
+func (p *Presence) List(room string) []string {
+	p.RLock()
+	defer p.RUnlock()
+
+	users := make(map[string]struct{})
+	for userID, conns := range p.local {
+		for kws := range conns {
+			if room == "" || contains(kws.Rooms(), room) {
+				users[userID] = struct{}{}
+				break
+			}
+		}
+	}
+	for _, remote := range p.remote {
+		for userID, rooms := range remote {
+			if room == "" || contains(rooms, room) {
+				users[userID] = struct{}{}
+			}
+		}
+	}
+	return sortedKeys(users)
+}
+
+// Must be called with the lock held
+func (p *Presence) online(userID string) bool {
+	if len(p.local[userID]) > 0 {
+		return true
+	}
+	for _, remote := range p.remote {
+		if _, ok := remote[userID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Add the connection to the user, returns EventOnline
+// if the user was offline
+func (p *Presence) add(kws *Websocket, userID string) string {
+	p.Lock()
+	defer p.Unlock()
+	wasOnline := p.online(userID)
+	if _, ok := p.local[userID]; !ok {
+		p.local[userID] = make(map[*Websocket]struct{})
+	}
+	p.local[userID][kws] = struct{}{}
+	if wasOnline {
+		return ""
+	}
+	return EventOnline
+}
+
+// Remove the connection from the user, returns EventOffline if the
+// user is now offline and false if the connection was not tracked
+func (p *Presence) remove(kws *Websocket, userID string) (string, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.local[userID][kws]; !ok {
+		return "", false
+	}
+	delete(p.local[userID], kws)
+	if len(p.local[userID]) == 0 {
+		delete(p.local, userID)
+	}
+	if p.online(userID) {
+		return "", true
+	}
+	return EventOffline, true
+}
+
+// Publish the change of the user and fire its event, if any
+func (p *Presence) notify(kws *Websocket, userID string, event string) {
+	p.publish(kws, userID)
+	p.heartbeat()
+	if event != "" {
+		kws.fireEvent(event, []byte(userID), nil)
+	}
+}
+
+// Send the rooms of the user on this node to the other nodes,
+// an offline user has no rooms
+func (p *Presence) publish(kws *Websocket, userID string) {
+	if p.hub.getAdapter() == nil {
+		return
+	}
+
+	p.RLock()
+	var rooms []string
+	for conn := range p.local[userID] {
+		rooms = append(rooms, conn.Rooms()...)
+	}
+	event := EventOffline
+	if len(p.local[userID]) > 0 {
+		event = EventOnline
+	}
+	p.RUnlock()
+
+	data, _ := json.Marshal(rooms)
+	err := p.hub.publish(AdapterMessage{
+		Kind:   AdapterPresence,
+		Target: userID,
+		Event:  event,
+		Data:   data,
+	})
+	if err != nil {
+		kws.fireEvent(EventError, []byte(userID), err)
+	}
+}
+
+// Apply the presence of a user on another node
+func (p *Presence) apply(msg AdapterMessage) {
+	var rooms []string
+	_ = json.Unmarshal(msg.Data, &rooms)
+
+	p.replace(msg.Node, func(users map[string][]string) map[string][]string {
+		if msg.Event == EventOnline {
+			users[msg.Target] = rooms
+		} else {
+			delete(users, msg.Target)
+		}
+		return users
+	})
+}
+
+// Replace the users of another node with the ones it sent
+func (p *Presence) sync(msg AdapterMessage) {
+	users := make(map[string][]string)
+	_ = json.Unmarshal(msg.Data, &users)
+
+	p.replace(msg.Node, func(map[string][]string) map[string][]string {
+		return users
+	})
+}
+
+// Replace the users of the node with the ones returned by the update,
+// which receives a copy of the current ones. The lease of the node is
+// renewed and the users going online or offline are fired.
+func (p *Presence) replace(node string, update func(users map[string][]string) map[string][]string) {
+	p.Lock()
+	current := make(map[string][]string, len(p.remote[node]))
+	for userID, rooms := range p.remote[node] {
+		current[userID] = rooms
+	}
+	users := update(current)
+
+	wasOnline := make(map[string]bool)
+	for userID := range p.remote[node] {
+		wasOnline[userID] = p.online(userID)
+	}
+	for userID := range users {
+		wasOnline[userID] = p.online(userID)
+	}
+
+	if len(users) > 0 {
+		p.remote[node] = users
+		p.expires[node] = time.Now().Add(p.ttl)
+	} else {
+		delete(p.remote, node)
+		delete(p.expires, node)
+	}
+
+	online := make(map[string]struct{})
+	offline := make(map[string]struct{})
+	for userID, was := range wasOnline {
+		switch is := p.online(userID); {
+		case is && !was:
+			online[userID] = struct{}{}
+		case !is && was:
+			offline[userID] = struct{}{}
+		}
+	}
+	p.Unlock()
+
+	p.changed(offline, false)
+	p.changed(online, true)
+	p.heartbeat()
+}
+
+// Remove the users of the nodes whose lease expired
+func (p *Presence) expire() {
+	now := time.Now()
+
+	p.Lock()
+	offline := make(map[string]struct{})
+	for node, expires := range p.expires {
+		if expires.After(now) {
+			continue
+		}
+		users := p.remote[node]
+		delete(p.remote, node)
+		delete(p.expires, node)
+		for userID := range users {
+			if !p.online(userID) {
+				offline[userID] = struct{}{}
+			}
+		}
+	}
+	p.Unlock()
+
+	p.changed(offline, false)
+}
+
+// OnChange Register a callback for the users going online or offline
+// on the other nodes of the cluster. The callbacks run in the go routine
+// of the adapter, a panic is not recovered.
+func (p *Presence) OnChange(callback func(userID string, online bool)) {
+	p.Lock()
+	defer p.Unlock()
+	p.callbacks = append(p.callbacks, callback)
+}
+
+// Pass the users to the callbacks, in the order of the user ids
+func (p *Presence) changed(users map[string]struct{}, online bool) {
+	p.RLock()
+	callbacks := make([]func(string, bool), len(p.callbacks))
+	copy(callbacks, p.callbacks)
+	p.RUnlock()
+
+	for _, userID := range sortedKeys(users) {
+		for _, callback := range callbacks {
+			callback(userID, online)
+		}
+	}
+}
+
+// Fire the error on the local connections, their users
+// could not be sent to the other nodes
+func (p *Presence) failed(err error) {
+	p.RLock()
+	conns := make(map[*Websocket]string)
+	for userID, local := range p.local {
+		for conn := range local {
+			conns[conn] = userID
+		}
+	}
+	p.RUnlock()
+
+	for conn, userID := range conns {
+		conn.fireEvent(EventError, []byte(userID), err)
+	}
+}
+
+// Ask the other nodes for their users and send them the local ones
+func (p *Presence) join() {
+	if p.hub.getAdapter() == nil {
+		return
+	}
+	_ = p.hub.publish(AdapterMessage{Kind: AdapterPresenceRequest})
+	_ = p.snapshot("")
+}
+
+// Send all the local users with their rooms to the node,
+// to all the nodes when it is empty
+func (p *Presence) snapshot(node string) error {
+	p.RLock()
+	users := make(map[string][]string, len(p.local))
+	for userID, conns := range p.local {
+		rooms := make([]string, 0)
+		for conn := range conns {
+			rooms = append(rooms, conn.Rooms()...)
+		}
+		users[userID] = rooms
+	}
+	p.RUnlock()
+
+	// the other nodes have no users of this node to refresh
+	if len(users) == 0 {
+		return nil
+	}
+
+	data, _ := json.Marshal(users)
+	return p.hub.publish(AdapterMessage{
+		Kind: AdapterPresenceSync,
+		To:   node,
+		Data: data,
+	})
+}
+
+// Start the heartbeat unless it is running, there is no adapter or the
+// hub is closing. It stops by itself when there are no users to send
+// or to expire.
+func (p *Presence) heartbeat() {
+	if p.hub.getAdapter() == nil || !p.hub.accepting() {
+		return
+	}
+
+	p.Lock()
+	if p.stop != nil || (len(p.local) == 0 && len(p.remote) == 0) {
+		p.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	p.stop = stop
+	ttl := p.ttl
+	p.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := p.snapshot(""); err != nil {
+					p.failed(err)
+				}
+				p.expire()
+				if p.idle(stop) {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop the heartbeat if there are no users left, the users tracked
+// afterwards start a new one. Returns false if it continues.
+func (p *Presence) idle(stop chan struct{}) bool {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.local) > 0 || len(p.remote) > 0 {
+		return false
+	}
+	if p.stop == stop {
+		p.stop = nil
+	}
+	return true
+}
+
+// Stop the heartbeat
+func (p *Presence) close() {
+	p.Lock()
+	defer p.Unlock()
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+}
+
+// The rooms of the connection changed
+func (h *Hub) presenceChanged(kws *Websocket) {
+	p := h.getPresence()
+	if p == nil {
+		return
+	}
+	if userID := kws.UserID(); userID != "" {
+		p.publish(kws, userID)
+	}
+}
+
+// Remove the disconnected connection from the presence
+func (h *Hub) untrack(kws *Websocket) {
+	p := h.getPresence()
+	if p == nil {
+		return
+	}
+
+	// a concurrent SetUserID either completed or sees the connection dead
+	kws.userMu.Lock()
+	userID := kws.UserID()
+	var event string
+	var removed bool
+	if userID != "" {
+		event, removed = p.remove(kws, userID)
+	}
+	kws.userMu.Unlock()
+
+	if removed {
+		p.notify(kws, userID, event)
+	}
+}
+
+// GetPresence The online users of the default hub
+func GetPresence() *Presence {
+	return defaultHub.Presence()
+}
@@ -0,0 +1,205 @@
+package ikisocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresence(t *testing.T) {
+	h := NewHub()
+	presence := h.Presence()
+
+	var changes []string
+	h.On(EventOnline, func(payload *EventPayload) {
+		changes = append(changes, "online "+string(payload.Data))
+	})
+	h.On(EventOffline, func(payload *EventPayload) {
+		changes = append(changes, "offline "+string(payload.Data))
+	})
+
+	first := createWS(withHub(h), withDone())
+	second := createWS(withHub(h), withDone())
+	other := createWS(withHub(h), withDone())
+
+	presence.Track(first, "alice")
+	presence.Track(second, "alice")
+	presence.Track(other, "bob")
+	require.Equal(t, []string{"online alice", "online bob"}, changes)
+	require.True(t, presence.Online("alice"))
+	require.Equal(t, []string{"alice", "bob"}, presence.List(""))
+
+	second.Join("room")
+	require.Equal(t, []string{"alice"}, presence.List("room"))
+
+	// the user is online until its last connection leaves
+	changes = nil
+	second.disconnected(nil)
+	require.Empty(t, changes)
+	require.True(t, presence.Online("alice"))
+	require.Empty(t, presence.List("room"))
+
+	first.disconnected(nil)
+	require.Equal(t, []string{"offline alice"}, changes)
+	require.False(t, presence.Online("alice"))
+
+	// moving a connection to another user
+	changes = nil
+	presence.Track(other, "carol")
+	require.Equal(t, []string{"offline bob", "online carol"}, changes)
+	require.Equal(t, []string{"carol"}, presence.List(""))
+
+	// disconnected connections are not tracked
+	presence.Track(first, "alice")
+	require.False(t, presence.Online("alice"))
+}
+
+func TestPresenceCluster(t *testing.T) {
+	a, _, b, _ := createClusterTest(t)
+
+	var changes []string
+	b.Presence().OnChange(func(userID string, online bool) {
+		if online {
+			changes = append(changes, "online "+userID)
+		} else {
+			changes = append(changes, "offline "+userID)
+		}
+	})
+	// the last connection of the user is disconnected on this node
+	b.On(EventOffline, func(payload *EventPayload) {
+		require.NotNil(t, payload.Kws)
+		changes = append(changes, "offline "+string(payload.Data))
+	})
+
+	kws := createWS(withHub(a), withDone())
+	local := createWS(withHub(b), withDone())
+
+	a.Presence().Track(kws, "alice")
+	require.True(t, b.Presence().Online("alice"))
+	require.Equal(t, []string{"online alice"}, changes)
+
+	kws.Join("room")
+	require.Equal(t, []string{"alice"}, b.Presence().List("room"))
+
+	// already online on the other node
+	b.Presence().Track(local, "alice")
+	require.Equal(t, []string{"online alice"}, changes)
+
+	kws.disconnected(nil)
+	require.True(t, b.Presence().Online("alice"))
+	require.Empty(t, b.Presence().List("room"))
+	require.True(t, a.Presence().Online("alice"))
+
+	local.disconnected(nil)
+	require.Equal(t, []string{"online alice", "offline alice"}, changes)
+	require.False(t, a.Presence().Online("alice"))
+}
+
+func TestPresenceJoin(t *testing.T) {
+	bus := NewMemoryBus()
+
+	a := NewHub()
+	require.Nil(t, a.UseAdapter(bus.Adapter()))
+	a.Presence().Track(createWS(withHub(a), withDone()), "alice")
+
+	// the users tracked before the adapter are sent on join
+	b := NewHub()
+	b.Presence().Track(createWS(withHub(b), withDone()), "bob")
+	require.Nil(t, b.UseAdapter(bus.Adapter()))
+	require.True(t, a.Presence().Online("bob"))
+	require.True(t, b.Presence().Online("alice"))
+
+	// a node joining later asks for the users of the others
+	c := NewHub()
+	require.Nil(t, c.UseAdapter(bus.Adapter()))
+	require.Equal(t, []string{"alice", "bob"}, c.Presence().List(""))
+}
+
+func TestPresenceLease(t *testing.T) {
+	bus := NewMemoryBus()
+
+	crashed := NewHub()
+	adapter := bus.Adapter()
+	require.Nil(t, crashed.UseAdapter(adapter))
+
+	h := NewHub()
+	h.Presence().ttl = 60 * time.Millisecond
+	require.Nil(t, h.UseAdapter(bus.Adapter()))
+
+	offline := make(chan string, 1)
+	h.Presence().OnChange(func(userID string, online bool) {
+		if !online {
+			offline <- userID
+		}
+	})
+
+	crashed.Presence().Track(createWS(withHub(crashed), withDone()), "alice")
+	require.True(t, h.Presence().Online("alice"))
+
+	// the users of a node that stops sending them expire
+	crashed.Presence().close()
+	require.Nil(t, adapter.Close())
+	select {
+	case userID := <-offline:
+		require.Equal(t, "alice", userID)
+	case <-time.After(time.Second):
+		t.Fatal("the users of the crashed node did not expire")
+	}
+	require.False(t, h.Presence().Online("alice"))
+}
+
+// Adapter failing the published messages once fail is called
+type failingAdapter struct {
+	Adapter
+	mu  sync.Mutex
+	err error
+}
+
+func (a *failingAdapter) fail(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.err = err
+}
+
+func (a *failingAdapter) Publish(ctx context.Context, msg AdapterMessage) error {
+	a.mu.Lock()
+	err := a.err
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return a.Adapter.Publish(ctx, msg)
+}
+
+func TestPresenceHeartbeatError(t *testing.T) {
+	h := NewHub()
+	adapter := &failingAdapter{Adapter: NewMemoryBus().Adapter()}
+	require.Nil(t, h.UseAdapter(adapter))
+	h.Presence().ttl = 30 * time.Millisecond
+
+	errs := make(chan *EventPayload, 1)
+	h.On(EventError, func(payload *EventPayload) {
+		select {
+		case errs <- payload:
+		default:
+		}
+	})
+
+	kws := createWS(withHub(h), withDone())
+	h.Presence().Track(kws, "alice")
+	adapter.fail(errors.New("unavailable"))
+
+	// the users that could not be sent are fired on their connections
+	select {
+	case payload := <-errs:
+		require.Equal(t, kws, payload.Kws)
+		require.Equal(t, "alice", string(payload.Data))
+	case <-time.After(time.Second):
+		t.Fatal("the heartbeat error was not fired")
+	}
+	h.Presence().close()
+}
@@ -0,0 +1,68 @@
+package redisadapter
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Releases the key only if it is still owned by the node
+var unregisterScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Directory Redis implementation of ikisocket.Directory,
+// every uuid is a key holding the owner node that expires with its lease
+type Directory struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewDirectory Create a new Redis directory, panics without a client
+func NewDirectory(config Config) *Directory {
+	if config.Client == nil {
+		panic(ErrorMissingClient)
+	}
+	if config.Prefix == "" {
+		config.Prefix = ConfigDefault.Prefix
+	}
+
+	return &Directory{
+		client: config.Client,
+		prefix: config.Prefix,
+	}
+}
+
+// Key of a connection uuid
+func (d *Directory) key(uuid string) string {
+	return d.prefix + ":conn:" + uuid
+}
+
+// Register Claim, or refresh, the uuids for the node until the ttl expires
+func (d *Directory) Register(ctx context.Context, node string, ttl time.Duration, uuids ...string) error {
+	_, err := d.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, wsUUID := range uuids {
+			pipe.Set(ctx, d.key(wsUUID), node, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// Unregister Release the uuid, only if it is owned by the node
+func (d *Directory) Unregister(ctx context.Context, node string, uuid string) error {
+	return unregisterScript.Run(ctx, d.client, []string{d.key(uuid)}, node).Err()
+}
+
+// Lookup The node owning the uuid, empty if unknown or expired
+func (d *Directory) Lookup(ctx context.Context, uuid string) (string, error) {
+	node, err := d.client.Get(ctx, d.key(uuid)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return node, err
+}
@@ -0,0 +1,41 @@
+module github.com/antoniodipinto/ikisocket/redisadapter
+
+go 1.18
+
+require (
+	github.com/alicebob/miniredis/v2 v2.31.1
+	github.com/antoniodipinto/ikisocket v0.0.0-20261016205449-bed1d31d61ea
+	github.com/fasthttp/websocket v1.5.4
+	github.com/gofiber/fiber/v2 v2.50.0
+	github.com/redis/go-redis/v9 v9.5.1
+	github.com/stretchr/testify v1.8.4
+	github.com/valyala/fasthttp v1.50.0
+)
+
+require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/andybalholm/brotli v1.0.5 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/gofiber/contrib/websocket v1.2.0 // indirect
+	github.com/google/uuid v1.3.1 // indirect
+	github.com/klauspost/compress v1.16.7 // indirect
+	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.19 // indirect
+	github.com/mattn/go-runewidth v0.0.15 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/rivo/uniseg v0.4.4 // indirect
+	github.com/savsgio/gotils v0.0.0-20230208104028-c358bd845dee // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/valyala/tcplisten v1.0.0 // indirect
+	github.com/vmihailenco/msgpack/v5 v5.4.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/yuin/gopher-lua v1.1.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+// Local development against the parent directory, ignored by the modules
+// requiring the adapter
+replace github.com/antoniodipinto/ikisocket => ../
@@ -0,0 +1,162 @@
+// Package redisadapter Redis pub/sub adapter distributing the
+// Broadcast/EmitTo/EmitToRoom/Fire of ikisocket hubs between nodes.
+//
+// Every node subscribes to a channel shared by the cluster and to its own
+// node channel. Targeted emits are delivered only by the node owning the
+// uuid, the other nodes ignore them; with the Directory the owner node
+// is looked up and the emit is published on its node channel only.
+// The subscription is restored by the Redis client when the connection
+// to the server is lost.
+//
+// The adapter is a separate module, the applications that do not use it
+// do not depend on the Redis client.
+package redisadapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/antoniodipinto/ikisocket"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrorMissingClient The config does not provide a Redis client
+	ErrorMissingClient = errors.New("redis adapter config requires a Client")
+	// ErrorAlreadySubscribed Subscribe has already been called on the adapter
+	ErrorAlreadySubscribed = errors.New("redis adapter is already subscribed")
+)
+
+// Config defines the config of the adapter
+type Config struct {
+	// Client Redis client used to publish and subscribe
+	//
+	// Required.
+	Client redis.UniversalClient
+
+	// Prefix of the channel names, nodes sharing the prefix
+	// belong to the same cluster
+	//
+	// Optional. Default: "ikisocket"
+	Prefix string
+}
+
+// ConfigDefault is the default config
+var ConfigDefault = Config{
+	Prefix: "ikisocket",
+}
+
+// Adapter Redis pub/sub implementation of ikisocket.Adapter
+type Adapter struct {
+	mu     sync.Mutex
+	client redis.UniversalClient
+	prefix string
+	node   string
+	pubsub *redis.PubSub
+	// Closed when the delivery go routine exits
+	done chan struct{}
+}
+
+// New Create a new Redis adapter, panics without a client
+func New(config Config) *Adapter {
+	if config.Client == nil {
+		panic(ErrorMissingClient)
+	}
+	if config.Prefix == "" {
+		config.Prefix = ConfigDefault.Prefix
+	}
+
+	return &Adapter{
+		client: config.Client,
+		prefix: config.Prefix,
+	}
+}
+
+// Channel shared by all the nodes
+func (a *Adapter) allChannel() string {
+	return a.prefix + ":all"
+}
+
+// Channel of a single node
+func (a *Adapter) nodeChannel(node string) string {
+	return a.prefix + ":node:" + node
+}
+
+// Publish Send the message to the other nodes,
+// only to the To node when it is set
+func (a *Adapter) Publish(ctx context.Context, msg ikisocket.AdapterMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	channel := a.allChannel()
+	if msg.To != "" {
+		channel = a.nodeChannel(msg.To)
+	}
+	return a.client.Publish(ctx, channel, payload).Err()
+}
+
+// Subscribe Deliver to the handler the messages published by the other
+// nodes for all the nodes or addressed to the given node
+func (a *Adapter) Subscribe(node string, handler func(msg ikisocket.AdapterMessage)) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.pubsub != nil {
+		return ErrorAlreadySubscribed
+	}
+
+	ctx := context.Background()
+	pubsub := a.client.Subscribe(ctx, a.allChannel(), a.nodeChannel(node))
+
+	// wait for the confirmation, so the messages published
+	// after Subscribe returns are not lost
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return err
+	}
+
+	a.node = node
+	a.pubsub = pubsub
+	a.done = make(chan struct{})
+
+	go a.listen(pubsub.Channel(), handler, a.done)
+	return nil
+}
+
+// Deliver the messages until the subscription is closed
+func (a *Adapter) listen(messages <-chan *redis.Message, handler func(msg ikisocket.AdapterMessage), done chan struct{}) {
+	defer close(done)
+
+	for m := range messages {
+		var msg ikisocket.AdapterMessage
+		if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+			continue
+		}
+
+		// avoid echoes of the own publications
+		if msg.Node == a.node {
+			continue
+		}
+		handler(msg)
+	}
+}
+
+// Close Stop the subscription, the client is not closed
+func (a *Adapter) Close() error {
+	a.mu.Lock()
+	pubsub, done := a.pubsub, a.done
+	a.pubsub = nil
+	a.mu.Unlock()
+
+	if pubsub == nil {
+		return nil
+	}
+
+	err := pubsub.Close()
+	<-done
+	return err
+}
@@ -0,0 +1,197 @@
+package redisadapter
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/antoniodipinto/ikisocket"
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func newTestAdapter(t *testing.T, mr *miniredis.Miniredis) *Adapter {
+	client := redis.NewClient(&redis.Options{
+		Addr:            mr.Addr(),
+		MinRetryBackoff: 10 * time.Millisecond,
+		MaxRetryBackoff: 50 * time.Millisecond,
+	})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return New(Config{Client: client})
+}
+
+func subscribeTestAdapter(t *testing.T, a *Adapter, node string) chan ikisocket.AdapterMessage {
+	received := make(chan ikisocket.AdapterMessage, 10)
+	require.Nil(t, a.Subscribe(node, func(msg ikisocket.AdapterMessage) {
+		received <- msg
+	}))
+	t.Cleanup(func() {
+		_ = a.Close()
+	})
+	return received
+}
+
+func TestAdapterPublishSubscribe(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	a := newTestAdapter(t, mr)
+	b := newTestAdapter(t, mr)
+	receivedA := subscribeTestAdapter(t, a, "node-a")
+	receivedB := subscribeTestAdapter(t, b, "node-b")
+
+	ctx := context.Background()
+	require.Nil(t, a.Publish(ctx, ikisocket.AdapterMessage{
+		Kind: ikisocket.AdapterBroadcast,
+		Node: "node-a",
+		Data: []byte("test"),
+	}))
+
+	msg := <-receivedB
+	require.Equal(t, ikisocket.AdapterBroadcast, msg.Kind)
+	require.Equal(t, "test", string(msg.Data))
+
+	// addressed to a single node
+	require.Nil(t, b.Publish(ctx, ikisocket.AdapterMessage{
+		Kind:   ikisocket.AdapterEmit,
+		Node:   "node-b",
+		To:     "node-a",
+		Target: "uuid",
+	}))
+
+	msg = <-receivedA
+	require.Equal(t, ikisocket.AdapterEmit, msg.Kind)
+	require.Equal(t, "uuid", msg.Target)
+
+	// no echo of the own publications
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, receivedA)
+	require.Empty(t, receivedB)
+
+	require.Equal(t, ErrorAlreadySubscribed, a.Subscribe("node-a", nil))
+}
+
+func TestAdapterReconnect(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	a := newTestAdapter(t, mr)
+	b := newTestAdapter(t, mr)
+	_ = subscribeTestAdapter(t, a, "node-a")
+	receivedB := subscribeTestAdapter(t, b, "node-b")
+
+	mr.Close()
+	require.Nil(t, mr.Restart())
+
+	// the subscription is restored by the client
+	require.Eventually(t, func() bool {
+		_ = a.Publish(context.Background(), ikisocket.AdapterMessage{
+			Kind: ikisocket.AdapterBroadcast,
+			Node: "node-a",
+		})
+		select {
+		case <-receivedB:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNewWithoutClient(t *testing.T) {
+	require.PanicsWithValue(t, ErrorMissingClient, func() {
+		New(Config{})
+	})
+}
+
+func TestClusterBroadcast(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	nodeA := ikisocket.NewHub()
+	nodeB := ikisocket.NewHub()
+	require.Nil(t, nodeA.UseAdapter(newTestAdapter(t, mr)))
+	require.Nil(t, nodeB.UseAdapter(newTestAdapter(t, mr)))
+
+	connected := make(chan string, 1)
+	nodeB.On(ikisocket.EventConnect, func(payload *ikisocket.EventPayload) {
+		connected <- payload.SocketUUID
+	})
+
+	fired := make(chan string, 1)
+	nodeB.On("clusterevent", func(payload *ikisocket.EventPayload) {
+		fired <- string(payload.Data)
+	})
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/", nodeB.New(func(kws *ikisocket.Websocket) {}))
+	ln := fasthttputil.NewInmemoryListener()
+	go func() {
+		_ = app.Listener(ln)
+	}()
+	defer func() {
+		_ = app.Shutdown()
+		_ = ln.Close()
+	}()
+
+	dialer := &websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	conn, _, err := dialer.Dial("ws://"+ln.Addr().String(), nil)
+	require.Nil(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	uuid := <-connected
+
+	nodeA.Broadcast([]byte("broadcast"))
+	_, msg, err := conn.ReadMessage()
+	require.Nil(t, err)
+	require.Equal(t, "broadcast", string(msg))
+
+	require.Nil(t, nodeA.EmitTo(uuid, []byte("emit")))
+	_, msg, err = conn.ReadMessage()
+	require.Nil(t, err)
+	require.Equal(t, "emit", string(msg))
+
+	nodeA.Fire("clusterevent", []byte("fire"))
+	require.Equal(t, "fire", <-fired)
+}
+
+func TestDirectory(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer func() {
+		_ = client.Close()
+	}()
+
+	d := NewDirectory(Config{Client: client})
+	ctx := context.Background()
+
+	require.Nil(t, d.Register(ctx, "node-a", time.Second, "uuid-1", "uuid-2"))
+
+	node, err := d.Lookup(ctx, "uuid-1")
+	require.Nil(t, err)
+	require.Equal(t, "node-a", node)
+
+	// only the owner releases the uuid
+	require.Nil(t, d.Unregister(ctx, "node-b", "uuid-1"))
+	node, _ = d.Lookup(ctx, "uuid-1")
+	require.Equal(t, "node-a", node)
+
+	require.Nil(t, d.Unregister(ctx, "node-a", "uuid-1"))
+	node, _ = d.Lookup(ctx, "uuid-1")
+	require.Equal(t, "", node)
+
+	// the lease of a crashed node expires
+	mr.FastForward(2 * time.Second)
+	node, err = d.Lookup(ctx, "uuid-2")
+	require.Nil(t, err)
+	require.Equal(t, "", node)
+}
@@ -0,0 +1,256 @@
+package ikisocket
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrorRetransmitBufferFull Too many reliable messages are waiting
+	// for the acknowledgement of the client
+	ErrorRetransmitBufferFull = errors.New("retransmit buffer of the connection is full")
+	// ErrorDeliveryFailed The client did not acknowledge a reliable message
+	// after MaxRetransmits or before the disconnection, error data is the message
+	ErrorDeliveryFailed = errors.New("reliable message not acknowledged by the client")
+)
+
+// Reliable message waiting for the acknowledgement of the client
+type pendingMessage struct {
+	frame    []byte
+	data     []byte
+	sentAt   time.Time
+	attempts int
+}
+
+type safeReliable struct {
+	sync.Mutex
+	// Pending messages by sequence number
+	list map[uint64]*pendingMessage
+	// State of the retransmit go routine
+	running bool
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// Start the go routine once, unless the connection is stopped
+func (r *safeReliable) start(fn func()) {
+	r.Lock()
+	defer r.Unlock()
+	if r.running || r.stopped {
+		return
+	}
+	r.running = true
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		fn()
+	}()
+}
+
+// Wait for the go routine, it cannot be started anymore
+func (r *safeReliable) stop() {
+	r.Lock()
+	r.stopped = true
+	r.Unlock()
+	r.wg.Wait()
+}
+
+// Add the message, returns false if there are already size pending messages
+func (r *safeReliable) add(seq uint64, msg *pendingMessage, size int) bool {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.list) >= size {
+		return false
+	}
+	if r.list == nil {
+		r.list = make(map[uint64]*pendingMessage)
+	}
+	r.list[seq] = msg
+	return true
+}
+
+func (r *safeReliable) remove(seq uint64) bool {
+	r.Lock()
+	defer r.Unlock()
+	_, ok := r.list[seq]
+	delete(r.list, seq)
+	return ok
+}
+
+// Returns true once a reliable message has been emitted
+func (r *safeReliable) used() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.running
+}
+
+// The messages sent before the deadline, in sequence order,
+// and the ones that exceeded the max attempts, removed
+func (r *safeReliable) expired(deadline time.Time, maxAttempts int) ([][]byte, [][]byte) {
+	r.Lock()
+	defer r.Unlock()
+
+	seqs := make([]uint64, 0, len(r.list))
+	for seq := range r.list {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+
+	var resend, failed [][]byte
+	for _, seq := range seqs {
+		msg := r.list[seq]
+		if msg.sentAt.After(deadline) {
+			continue
+		}
+		if msg.attempts >= maxAttempts {
+			delete(r.list, seq)
+			failed = append(failed, msg.data)
+			continue
+		}
+		msg.attempts++
+		msg.sentAt = time.Now()
+		resend = append(resend, msg.frame)
+	}
+	return resend, failed
+}
+
+// Remove all the pending messages and return their data
+func (r *safeReliable) reset() [][]byte {
+	r.Lock()
+	defer r.Unlock()
+	var ret [][]byte
+	for _, msg := range r.list {
+		ret = append(ret, msg.data)
+	}
+	r.list = nil
+	return ret
+}
+
+// Last inbound envelope ids, with the acknowledgements sent for them
+type safeSeen struct {
+	sync.Mutex
+	acks  map[uint64][]byte
+	order []uint64
+}
+
+// Remember the id, returns the acknowledgement already sent
+// and true if the id was already received
+func (s *safeSeen) add(id uint64, size int) ([]byte, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if ack, ok := s.acks[id]; ok {
+		return ack, true
+	}
+	if s.acks == nil {
+		s.acks = make(map[uint64][]byte)
+	}
+	s.acks[id] = nil
+	s.order = append(s.order, id)
+	if len(s.order) > size {
+		delete(s.acks, s.order[0])
+		s.order = s.order[1:]
+	}
+	return nil, false
+}
+
+// Keep the acknowledgement of the id, if it is still in the window
+func (s *safeSeen) acked(id uint64, ack []byte) {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.acks[id]; ok {
+		s.acks[id] = ack
+	}
+}
+
+// EmitReliable Emit the event with its payload as an envelope with a
+// sequence number, sent again every RetransmitInterval until the client
+// acknowledges it sending back
+//
+//	{"ack_seq": <seq>}
+//
+// The client may receive a message more than once and should discard the
+// sequence numbers already handled. After MaxRetransmits, or when the
+// connection is closed, EventError is fired with ErrorDeliveryFailed and
+// the payload as data. Returns ErrorRetransmitBufferFull if
+// RetransmitBufferSize messages are waiting for the acknowledgement.
+func (kws *Websocket) EmitReliable(event string, payload []byte) error {
+	if !kws.IsAlive() {
+		return ErrorInvalidConnection
+	}
+
+	seq := atomic.AddUint64(&kws.reliableSeq, 1)
+	msg := &pendingMessage{
+		frame:  envelope{Event: event, Seq: seq}.encode(payload),
+		data:   payload,
+		sentAt: time.Now(),
+	}
+	if !kws.reliable.add(seq, msg, kws.config.RetransmitBufferSize) {
+		return ErrorRetransmitBufferFull
+	}
+
+	kws.reliable.start(kws.retransmit)
+
+	kws.write(TextMessage, msg.frame)
+	return nil
+}
+
+// Send again the messages not acknowledged in time,
+// until the connection is closed
+func (kws *Websocket) retransmit() {
+	ticker := time.NewTicker(kws.config.RetransmitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			resend, failed := kws.reliable.expired(time.Now().Add(-kws.config.RetransmitInterval), kws.config.MaxRetransmits)
+			for _, data := range failed {
+				kws.fireEvent(EventError, data, ErrorDeliveryFailed)
+			}
+			for _, frame := range resend {
+				kws.write(TextMessage, frame)
+			}
+		case <-kws.done:
+			for _, data := range kws.reliable.reset() {
+				kws.fireEvent(EventError, data, ErrorDeliveryFailed)
+			}
+			return
+		}
+	}
+}
+
+// Remove the reliable message acknowledged by the client,
+// returns false if the message is not an acknowledgement
+func (kws *Websocket) resolveReliable(msg []byte) bool {
+	if !kws.reliable.used() {
+		return false
+	}
+
+	e, ok := decodeEnvelope(msg)
+	if !ok || e.AckSeq == 0 {
+		return false
+	}
+
+	// acknowledgements of messages already removed are ignored too
+	kws.reliable.remove(e.AckSeq)
+	return true
+}
+
+// Returns true if the inbound envelope id has already been received
+// in the last DedupeWindow ones, the acknowledgement is sent again
+func (kws *Websocket) duplicate(id uint64) bool {
+	if id == 0 || kws.config.DedupeWindow <= 0 {
+		return false
+	}
+
+	ack, ok := kws.seen.add(id, kws.config.DedupeWindow)
+	if ok && ack != nil {
+		kws.write(TextMessage, ack)
+	}
+	return ok
+}
@@ -0,0 +1,120 @@
+package ikisocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readEnvelope(t *testing.T, kws *Websocket) envelope {
+	select {
+	case msg := <-kws.queue:
+		var e envelope
+		require.Nil(t, json.Unmarshal(msg.data, &e))
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no message queued")
+	}
+	return envelope{}
+}
+
+func TestEmitReliable(t *testing.T) {
+	kws := createWS(withHub(NewHub()), withConfig(configDefault(Config{RetransmitInterval: 20 * time.Millisecond})), withQueue(10), withDone())
+
+	require.Nil(t, kws.EmitReliable("notification", []byte("data")))
+	first := readEnvelope(t, kws)
+	require.Equal(t, "notification", first.Event)
+	require.Equal(t, uint64(1), first.Seq)
+	require.Equal(t, "data", string(first.data()))
+
+	// sent again until acknowledged
+	require.Equal(t, first, readEnvelope(t, kws))
+
+	require.True(t, kws.resolveReliable([]byte(`{"ack_seq": 1}`)))
+	// a late acknowledgement is not dispatched
+	require.True(t, kws.resolveReliable([]byte(`{"ack_seq": 1}`)))
+	require.False(t, kws.resolveReliable([]byte(`{"event": "other"}`)))
+
+	time.Sleep(60 * time.Millisecond)
+	for len(kws.queue) > 0 {
+		// a retransmission may have been queued before the acknowledgement
+		require.Equal(t, first, readEnvelope(t, kws))
+	}
+	time.Sleep(60 * time.Millisecond)
+	require.Empty(t, kws.queue)
+
+	require.Nil(t, kws.EmitReliable("notification", nil))
+	require.Equal(t, uint64(2), readEnvelope(t, kws).Seq)
+
+	kws.disconnected(nil)
+	kws.reliable.stop()
+}
+
+func TestEmitReliableFailed(t *testing.T) {
+	kws := createWS(withHub(NewHub()), withConfig(configDefault(Config{
+		RetransmitInterval:   10 * time.Millisecond,
+		MaxRetransmits:       2,
+		RetransmitBufferSize: 1,
+	})), withQueue(10), withDone())
+
+	failed := make(chan string, 2)
+	kws.hub.On(EventError, func(payload *EventPayload) {
+		require.Equal(t, ErrorDeliveryFailed, payload.Error)
+		failed <- string(payload.Data)
+	})
+
+	require.Nil(t, kws.EmitReliable("notification", []byte("first")))
+	require.Equal(t, ErrorRetransmitBufferFull, kws.EmitReliable("notification", []byte("second")))
+
+	// sent once and retransmitted twice
+	for i := 0; i < 3; i++ {
+		require.Equal(t, uint64(1), readEnvelope(t, kws).Seq)
+	}
+	require.Equal(t, "first", <-failed)
+
+	// the pending messages fail on disconnection
+	require.Nil(t, kws.EmitReliable("notification", []byte("third")))
+	kws.disconnected(nil)
+	kws.reliable.stop()
+	require.Equal(t, "third", <-failed)
+	require.Equal(t, ErrorInvalidConnection, kws.EmitReliable("notification", nil))
+}
+
+func TestDedupe(t *testing.T) {
+	kws := createWS(withHub(NewHub()), withConfig(configDefault(Config{
+		Envelope:     true,
+		DedupeWindow: 2,
+	})), withQueue(10), withDone())
+
+	calls := 0
+	kws.hub.On("event", func(payload *EventPayload) {
+		calls++
+		require.Nil(t, payload.Ack([]byte("ok")))
+	})
+
+	handle := func(msg string) {
+		payload := kws.decodeMessage(TextMessage, []byte(msg))
+		if !kws.duplicate(payload.ackID) {
+			kws.dispatch(payload)
+		}
+	}
+
+	handle(`{"event": "event", "id": 1}`)
+	require.Equal(t, 1, calls)
+	require.Equal(t, uint64(1), readEnvelope(t, kws).Ack)
+
+	// the duplicate is acknowledged again without calling the listeners
+	handle(`{"event": "event", "id": 1}`)
+	require.Equal(t, 1, calls)
+	ack := readEnvelope(t, kws)
+	require.Equal(t, uint64(1), ack.Ack)
+	require.Equal(t, "ok", string(ack.data()))
+
+	// the window keeps the last two ids
+	handle(`{"event": "event", "id": 2}`)
+	handle(`{"event": "event", "id": 3}`)
+	handle(`{"event": "event", "id": 1}`)
+	require.Equal(t, 4, calls)
+}
@@ -0,0 +1,235 @@
+package ikisocket
+
+import (
+	"sort"
+	"sync"
+)
+
+type safeRooms struct {
+	sync.RWMutex
+	// Connection uuids of every room
+	members map[string]map[string]struct{}
+	// Rooms of every connection uuid
+	joined map[string]map[string]struct{}
+}
+
+func newSafeRooms() *safeRooms {
+	return &safeRooms{
+		members: make(map[string]map[string]struct{}),
+		joined:  make(map[string]map[string]struct{}),
+	}
+}
+
+// Add the uuid to the room, returns false if it was already a member
+func (r *safeRooms) join(room string, uuid string) bool {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.members[room][uuid]; ok {
+		return false
+	}
+	if _, ok := r.members[room]; !ok {
+		r.members[room] = make(map[string]struct{})
+	}
+	if _, ok := r.joined[uuid]; !ok {
+		r.joined[uuid] = make(map[string]struct{})
+	}
+	r.members[room][uuid] = struct{}{}
+	r.joined[uuid][room] = struct{}{}
+	return true
+}
+
+// Remove the uuid from the room, returns false if it was not a member
+func (r *safeRooms) leave(room string, uuid string) bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.remove(room, uuid)
+}
+
+// Remove the uuid from all its rooms and return them
+func (r *safeRooms) leaveAll(uuid string) []string {
+	r.Lock()
+	defer r.Unlock()
+	ret := sortedKeys(r.joined[uuid])
+	for _, room := range ret {
+		r.remove(room, uuid)
+	}
+	return ret
+}
+
+// Must be called with the lock held
+func (r *safeRooms) remove(room string, uuid string) bool {
+	if _, ok := r.members[room][uuid]; !ok {
+		return false
+	}
+	delete(r.members[room], uuid)
+	if len(r.members[room]) == 0 {
+		delete(r.members, room)
+	}
+	delete(r.joined[uuid], room)
+	if len(r.joined[uuid]) == 0 {
+		delete(r.joined, uuid)
+	}
+	return true
+}
+
+func (r *safeRooms) get(room string) []string {
+	r.RLock()
+	defer r.RUnlock()
+	return sortedKeys(r.members[room])
+}
+
+func (r *safeRooms) of(uuid string) []string {
+	r.RLock()
+	defer r.RUnlock()
+	return sortedKeys(r.joined[uuid])
+}
+
+// Move the memberships of a uuid to a new uuid
+func (r *safeRooms) rename(from string, to string) {
+	r.Lock()
+	defer r.Unlock()
+	for _, room := range sortedKeys(r.joined[from]) {
+		r.remove(room, from)
+		if _, ok := r.members[room]; !ok {
+			r.members[room] = make(map[string]struct{})
+		}
+		if _, ok := r.joined[to]; !ok {
+			r.joined[to] = make(map[string]struct{})
+		}
+		r.members[room][to] = struct{}{}
+		r.joined[to][room] = struct{}{}
+	}
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	ret := make([]string, 0, len(set))
+	for key := range set {
+		ret = append(ret, key)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Join Add the connection to a room, a disconnected connection
+// does not join
+func (kws *Websocket) Join(room string) {
+	// serialized with the leaveAll of the disconnection, so a dead
+	// connection is never left in a room
+	kws.roomsMu.Lock()
+	joined := kws.IsAlive() && kws.getHub().rooms.join(room, kws.GetUUID())
+	kws.roomsMu.Unlock()
+
+	if joined {
+		kws.fireEvent(EventJoin, []byte(room), nil)
+		kws.getHub().presenceChanged(kws)
+	}
+}
+
+// Leave Remove the connection from a room
+func (kws *Websocket) Leave(room string) {
+	if kws.getHub().rooms.leave(room, kws.GetUUID()) {
+		kws.fireEvent(EventLeave, []byte(room), nil)
+		kws.getHub().presenceChanged(kws)
+	}
+}
+
+// Rooms List of the rooms joined by the connection
+func (kws *Websocket) Rooms() []string {
+	return kws.getHub().rooms.of(kws.GetUUID())
+}
+
+// EmitToRoom Emit the message to all the members of a room
+// except avoid sending the message to itself
+func (kws *Websocket) EmitToRoom(room string, message []byte, except bool, mType ...int) {
+	var skip []string
+	if except {
+		skip = append(skip, kws.GetUUID())
+	}
+	for _, err := range kws.getHub().emitToRoom(room, message, skip, mType...) {
+		kws.fireEvent(EventError, message, err)
+	}
+}
+
+// Remove the connection from all its rooms
+func (kws *Websocket) leaveAll() {
+	kws.roomsMu.Lock()
+	rooms := kws.getHub().rooms.leaveAll(kws.GetUUID())
+	kws.roomsMu.Unlock()
+
+	for _, room := range rooms {
+		kws.fireEvent(EventLeave, []byte(room), nil)
+	}
+}
+
+// EmitToRoom Emit the message to all the members of a room
+// except the given uuids, on every node when there is an adapter
+func (h *Hub) EmitToRoom(room string, message []byte, except ...string) {
+	h.EmitToRoomType(room, message, TextMessage, except...)
+}
+
+// EmitToRoomType Emit the message with the message type
+// to all the members of a room except the given uuids
+func (h *Hub) EmitToRoomType(room string, message []byte, mType int, except ...string) {
+	_ = h.emitToRoom(room, message, except, mType)
+}
+
+// RoomMembers List of the connection uuids that joined the room
+func (h *Hub) RoomMembers(room string) []string {
+	return h.rooms.get(room)
+}
+
+// Emit to the room members of the hub and of the other nodes
+func (h *Hub) emitToRoom(room string, message []byte, except []string, mType ...int) []error {
+	errs := h.emitToRoomLocal(room, message, except, mType...)
+	err := h.publish(AdapterMessage{
+		Kind:   AdapterRoom,
+		Target: room,
+		Data:   message,
+		MType:  messageType(mType),
+		Except: except,
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func (h *Hub) emitToRoomLocal(room string, message []byte, except []string, mType ...int) []error {
+	var errs []error
+	for _, wsUUID := range h.rooms.get(room) {
+		if contains(except, wsUUID) {
+			continue
+		}
+		if err := h.emitLocal(wsUUID, message, mType...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// EmitToRoom Emit the message to all the members of a room
+// of the default hub except the given uuids
+func EmitToRoom(room string, message []byte, except ...string) {
+	defaultHub.EmitToRoom(room, message, except...)
+}
+
+// EmitToRoomType Emit the message with the message type to all the
+// members of a room of the default hub except the given uuids
+func EmitToRoomType(room string, message []byte, mType int, except ...string) {
+	defaultHub.EmitToRoomType(room, message, mType, except...)
+}
+
+// RoomMembers List of the connection uuids that joined
+// the room of the default hub
+func RoomMembers(room string) []string {
+	return defaultHub.RoomMembers(room)
+}
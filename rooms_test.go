@@ -0,0 +1,122 @@
+package ikisocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebsocket_JoinLeave(t *testing.T) {
+	h := NewHub()
+
+	kws := createWS(withHub(h))
+
+	joined := new(HandlerMock)
+	joined.On("OnCustomEvent", mock.Anything).Return(nil)
+	left := new(HandlerMock)
+	left.On("OnCustomEvent", mock.Anything).Return(nil)
+	h.On(EventJoin, joined.OnCustomEvent)
+	h.On(EventLeave, left.OnCustomEvent)
+
+	joined.wg.Add(2)
+	kws.Join("lobby")
+	kws.Join("lobby")
+	kws.Join("games")
+	joined.wg.Wait()
+
+	joined.AssertNumberOfCalls(t, "OnCustomEvent", 2)
+	require.Equal(t, []string{"games", "lobby"}, kws.Rooms())
+	require.Equal(t, []string{kws.UUID}, h.RoomMembers("lobby"))
+
+	left.wg.Add(1)
+	kws.Leave("lobby")
+	kws.Leave("lobby")
+	left.wg.Wait()
+
+	left.AssertNumberOfCalls(t, "OnCustomEvent", 1)
+	require.Equal(t, []string{"games"}, kws.Rooms())
+	require.Empty(t, h.RoomMembers("lobby"))
+
+	kws.SetUUID("renamed")
+	require.Equal(t, []string{"renamed"}, h.RoomMembers("games"))
+}
+
+func TestHubEmitToRoom(t *testing.T) {
+	h := NewHub()
+
+	uuids := []string{"80a80sdf809dsf", "las3dfj09808", "s8df7s8df7"}
+	for _, id := range uuids {
+		kws := new(WebsocketMock)
+		kws.UUID = id
+		kws.On("Emit", mock.Anything).Return(nil)
+		kws.On("IsAlive").Return(true)
+		h.pool.set(kws)
+	}
+
+	h.rooms.join("lobby", uuids[0])
+	h.rooms.join("lobby", uuids[1])
+
+	h.pool.get(uuids[0]).(*WebsocketMock).wg.Add(1)
+	h.EmitToRoom("lobby", []byte("test"), uuids[1])
+	h.pool.get(uuids[0]).(*WebsocketMock).wg.Wait()
+
+	h.pool.get(uuids[0]).(*WebsocketMock).AssertNumberOfCalls(t, "Emit", 1)
+	h.pool.get(uuids[1]).(*WebsocketMock).AssertNumberOfCalls(t, "Emit", 0)
+	h.pool.get(uuids[2]).(*WebsocketMock).AssertNumberOfCalls(t, "Emit", 0)
+}
+
+func TestHubEmitToRoomType(t *testing.T) {
+	h := NewHub()
+
+	kws := createWS(withHub(h), withQueue(1))
+	kws.Join("lobby")
+
+	h.EmitToRoomType("lobby", []byte{0x01}, BinaryMessage)
+	msg := <-kws.queue
+	require.Equal(t, BinaryMessage, msg.mType)
+	require.Equal(t, []byte{0x01}, msg.data)
+}
+
+func TestWebsocket_RoomsCleanupOnDisconnect(t *testing.T) {
+	h := NewHub()
+
+	kws := createWS(withHub(h), withDone())
+
+	kws.Join("lobby")
+	kws.Join("games")
+
+	left := new(HandlerMock)
+	left.On("OnCustomEvent", mock.Anything).Return(nil)
+	h.On(EventLeave, left.OnCustomEvent)
+
+	left.wg.Add(2)
+	kws.disconnected(nil)
+	left.wg.Wait()
+
+	left.AssertNumberOfCalls(t, "OnCustomEvent", 2)
+	require.False(t, h.pool.contains(kws.UUID))
+	require.Empty(t, h.RoomMembers("lobby"))
+	require.Empty(t, h.RoomMembers("games"))
+
+	// a listener handling an earlier message does not join again
+	kws.Join("lobby")
+	require.Empty(t, h.RoomMembers("lobby"))
+	require.Empty(t, kws.Rooms())
+}
+
+func TestWebsocket_JoinDisconnected(t *testing.T) {
+	h := NewHub()
+
+	for i := 0; i < numTestConn; i++ {
+		kws := createWS(withHub(h), withDone())
+		go kws.disconnected(nil)
+		kws.Join("lobby")
+	}
+
+	// the dead connections are removed from the room
+	require.Eventually(t, func() bool {
+		return len(h.RoomMembers("lobby")) == 0
+	}, time.Second, 5*time.Millisecond)
+}
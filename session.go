@@ -0,0 +1,373 @@
+package ikisocket
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+)
+
+const (
+	// SessionTokenQuery Query parameter of the session token
+	// sent by a reconnecting client
+	SessionTokenQuery = "session"
+	// SessionSeqQuery Query parameter of the sequence number of the
+	// last message received by a reconnecting client
+	SessionSeqQuery = "last_seq"
+	// Envelope event carrying the session token to the client
+	sessionEvent = "session"
+)
+
+// Message sent to the client of a session
+type outboxMessage struct {
+	seq   uint64
+	mType int
+	data  []byte
+}
+
+// Resumable session of a connection.
+//
+// Every text and binary message sent to the client gets the next sequence
+// number, the client counts the messages received after the session frame
+// to know the sequence number of the last one.
+type session struct {
+	sync.Mutex
+	token string
+	// Sequence number of the last message
+	seq uint64
+	// Last messages, at most size
+	outbox []outboxMessage
+	size   int
+	// Connection of the session, the last one when resumed
+	kws *Websocket
+	// Fields of the parked session
+	uuid    string
+	userID  string
+	timer   *time.Timer
+	drained chan struct{}
+}
+
+// Payload of the session frame, seq is the sequence number
+// of the message preceding the next one
+type sessionFrame struct {
+	Token string `json:"token"`
+	Seq   uint64 `json:"seq"`
+}
+
+func newSession(size int) *session {
+	return &session{
+		token: uuid.New().String(),
+		size:  size,
+	}
+}
+
+// Assign the next sequence number to the message and keep it in the outbox
+func (s *session) record(mType int, data []byte) {
+	s.Lock()
+	defer s.Unlock()
+	s.seq++
+	s.outbox = append(s.outbox, outboxMessage{
+		seq:   s.seq,
+		mType: mType,
+		data:  data,
+	})
+	if len(s.outbox) > s.size {
+		s.outbox[0] = outboxMessage{}
+		s.outbox = s.outbox[1:]
+	}
+}
+
+// The messages following the one with the given sequence number and the
+// sequence number preceding them, greater than seq if some messages are
+// no longer in the outbox
+func (s *session) since(seq uint64) (uint64, []outboxMessage) {
+	s.Lock()
+	defer s.Unlock()
+	if seq >= s.seq {
+		return s.seq, nil
+	}
+
+	var messages []outboxMessage
+	for _, msg := range s.outbox {
+		if msg.seq > seq {
+			messages = append(messages, msg)
+		}
+	}
+	if len(messages) > 0 {
+		seq = messages[0].seq - 1
+	} else {
+		seq = s.seq
+	}
+	return seq, messages
+}
+
+// Wait for the queue of the previous connection to be kept,
+// returns false if it takes longer than the timeout
+func (s *session) waitDrained(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-s.drained:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// Parked sessions, waiting for the client to reconnect
+type safeSessions struct {
+	sync.Mutex
+	tokens map[string]*session
+	uuids  map[string]*session
+}
+
+func newSafeSessions() *safeSessions {
+	return &safeSessions{
+		tokens: make(map[string]*session),
+		uuids:  make(map[string]*session),
+	}
+}
+
+// Park the session, expire is called if it is not resumed within ttl
+func (ss *safeSessions) park(s *session, uuid string, ttl time.Duration, expire func()) {
+	ss.Lock()
+	defer ss.Unlock()
+	s.uuid = uuid
+	s.drained = make(chan struct{})
+	s.timer = time.AfterFunc(ttl, func() {
+		if ss.remove(s) {
+			expire()
+		}
+	})
+	ss.tokens[s.token] = s
+	ss.uuids[uuid] = s
+}
+
+// The parked session with the token, nil if there is none
+func (ss *safeSessions) byToken(token string) *session {
+	ss.Lock()
+	defer ss.Unlock()
+	return ss.tokens[token]
+}
+
+// Remove the parked session before it expires,
+// returns false if it is no longer parked
+func (ss *safeSessions) take(s *session) bool {
+	if !ss.remove(s) {
+		return false
+	}
+	s.timer.Stop()
+	return true
+}
+
+// Returns false if the session was not parked
+func (ss *safeSessions) remove(s *session) bool {
+	ss.Lock()
+	defer ss.Unlock()
+	if ss.tokens[s.token] != s {
+		return false
+	}
+	delete(ss.tokens, s.token)
+	delete(ss.uuids, s.uuid)
+	return true
+}
+
+// The parked session of the uuid, nil if there is none
+func (ss *safeSessions) get(uuid string) *session {
+	ss.Lock()
+	defer ss.Unlock()
+	return ss.uuids[uuid]
+}
+
+func (ss *safeSessions) all() []*session {
+	ss.Lock()
+	defer ss.Unlock()
+	ret := make([]*session, 0, len(ss.uuids))
+	for _, s := range ss.uuids {
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// Keep the message for a parked session, returns false if there is none
+func (h *Hub) emitParked(uuid string, message []byte, mType ...int) bool {
+	s := h.sessions.get(uuid)
+	if s == nil {
+		return false
+	}
+	s.record(messageType(mType), message)
+	return true
+}
+
+// Expire the parked session of the uuid, if any, before the uuid
+// is used by another connection
+func (h *Hub) releaseParked(uuid string) {
+	if s := h.sessions.get(uuid); s != nil && h.sessions.take(s) {
+		s.kws.expireSession()
+	}
+}
+
+// Keep the message for all the parked sessions but the excluded uuid
+func (h *Hub) broadcastParked(message []byte, except string, mType ...int) {
+	for _, s := range h.sessions.all() {
+		if s.uuid != except {
+			s.record(messageType(mType), message)
+		}
+	}
+}
+
+// Resume the parked session of the token or open a new one,
+// the session frame and the missed messages are queued. When the
+// endpoint identifies the clients only the sessions of the same user
+// are resumed.
+// Must be called before the connection is in the pool.
+func (kws *Websocket) openSession(token string, lastSeq string) {
+	h := kws.getHub()
+
+	s := h.sessions.byToken(token)
+	if userID, ok := kws.identifiedUser(); s != nil && ok && s.userID != userID {
+		// the session of another user stays parked for its owner
+		s = nil
+	}
+	if s != nil {
+		// wait for the queue of the previous connection, the session
+		// stays parked and may expire in the meantime
+		if !s.waitDrained(kws.config.CloseTimeout) || h.pool.contains(s.uuid) {
+			// not drained in time or the uuid is used by another connection
+			if h.sessions.take(s) {
+				s.kws.expireSession()
+			}
+			s = nil
+		} else if !h.sessions.take(s) {
+			s = nil
+		}
+	}
+	if s == nil {
+		s = newSession(kws.config.SessionOutboxSize)
+		s.kws = kws
+		kws.session = s
+		kws.queueSession(0, nil)
+		return
+	}
+
+	previous := s.kws
+	previous.mu.RLock()
+	for key, value := range previous.attributes {
+		kws.attributes[key] = value
+	}
+	previous.mu.RUnlock()
+
+	kws.resumed = true
+	s.kws = kws
+	kws.session = s
+
+	seq, _ := strconv.ParseUint(lastSeq, 10, 64)
+	kws.queueSession(s.since(seq))
+}
+
+// Take back the uuid of the resumed session, which still joins its rooms,
+// and its user. Called after the callback of the connection, that may set
+// the same uuid.
+func (kws *Websocket) restoreSession() {
+	s := kws.session
+	if kws.GetUUID() != s.uuid && !kws.getHub().pool.contains(s.uuid) {
+		kws.SetUUID(s.uuid)
+	}
+	if s.userID != "" && kws.UserID() == "" {
+		kws.SetUserID(s.userID)
+	}
+}
+
+// Queue the session frame and the messages to replay
+func (kws *Websocket) queueSession(seq uint64, replay []outboxMessage) {
+	// the send loop is not running yet
+	if cap(kws.queue)-len(kws.queue) <= len(replay) {
+		kws.queue = make(chan message, len(replay)+1+cap(kws.queue))
+	}
+
+	data, _ := json.Marshal(sessionFrame{
+		Token: kws.session.token,
+		Seq:   seq,
+	})
+	kws.queue <- message{
+		mType:       TextMessage,
+		data:        envelope{Event: sessionEvent}.encode(data),
+		unsequenced: true,
+	}
+	for _, msg := range replay {
+		kws.queue <- message{
+			mType:       msg.mType,
+			data:        msg.data,
+			unsequenced: true,
+		}
+	}
+}
+
+// Park the session of the disconnected connection,
+// returns false if the connection has no session to keep
+func (kws *Websocket) parkSession(err error) bool {
+	if kws.session == nil || kws.config.SessionTTL <= 0 {
+		return false
+	}
+
+	kws.mu.RLock()
+	closing := kws.closing
+	kws.mu.RUnlock()
+	// closed on purpose by the server or the client
+	if closing || websocket.IsCloseError(err, int(CloseNormalClosure)) {
+		return false
+	}
+
+	kws.session.userID = kws.UserID()
+	kws.getHub().sessions.park(kws.session, kws.GetUUID(), kws.config.SessionTTL, kws.expireSession)
+	kws.parked = true
+	return true
+}
+
+// Keep the messages left in the queue by the send loop,
+// called when the send loop is stopped
+func (kws *Websocket) drainSession() {
+	if !kws.parked {
+		return
+	}
+	s := kws.session
+	for {
+		select {
+		case msg := <-kws.queue:
+			if isData(msg) {
+				s.record(msg.mType, msg.data)
+			}
+		default:
+			close(s.drained)
+			return
+		}
+	}
+}
+
+// Release the rooms and the directory entry of an expired session
+func (kws *Websocket) expireSession() {
+	kws.leaveAll()
+	kws.unregister(kws.GetUUID())
+}
+
+// Returns true for the messages with a sequence number
+func isData(msg message) bool {
+	return !msg.unsequenced && (msg.mType == TextMessage || msg.mType == BinaryMessage)
+}
+
+// SessionToken Token of the session of the connection,
+// empty if the sessions are not enabled
+func (kws *Websocket) SessionToken() string {
+	if kws.session == nil {
+		return ""
+	}
+	return kws.session.token
+}
+
+// Resumed Returns true if the connection resumed a previous session
+func (kws *Websocket) Resumed() bool {
+	return kws.resumed
+}
@@ -0,0 +1,306 @@
+package ikisocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func dialSession(t *testing.T, ln *fasthttputil.InmemoryListener, token string, seq uint64) *websocket.Conn {
+	dialer := &websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	url := "ws://" + ln.Addr().String()
+	if token != "" {
+		url += fmt.Sprintf("/?%s=%s&%s=%d", SessionTokenQuery, token, SessionSeqQuery, seq)
+	}
+	conn, _, err := dialer.Dial(url, nil)
+	require.Nil(t, err)
+	return conn
+}
+
+// Read the session message sent on connection
+func readSession(t *testing.T, conn *websocket.Conn) sessionFrame {
+	_, msg, err := conn.ReadMessage()
+	require.Nil(t, err)
+
+	var env envelope
+	require.Nil(t, json.Unmarshal(msg, &env))
+	require.Equal(t, sessionEvent, env.Event)
+
+	var frame sessionFrame
+	require.Nil(t, json.Unmarshal(env.Data, &frame))
+	require.NotEmpty(t, frame.Token)
+	return frame
+}
+
+func readMessages(t *testing.T, conn *websocket.Conn, n int) []string {
+	messages := make([]string, n)
+	for i := range messages {
+		_, msg, err := conn.ReadMessage()
+		require.Nil(t, err)
+		messages[i] = string(msg)
+	}
+	return messages
+}
+
+func TestSessionResume(t *testing.T) {
+	h := NewHub(Config{SessionTTL: 5 * time.Second})
+
+	connected := make(chan *Websocket, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		if !kws.Resumed() {
+			kws.SetAttribute("user", "test")
+			kws.Join("room")
+		}
+		connected <- kws
+	}))
+	defer shutdown()
+
+	disconnected := make(chan struct{}, 1)
+	h.On(EventDisconnect, func(payload *EventPayload) {
+		disconnected <- struct{}{}
+	})
+	resumed := make(chan string, 1)
+	h.On(EventResume, func(payload *EventPayload) {
+		resumed <- payload.SocketUUID
+	})
+
+	conn := dialSession(t, ln, "", 0)
+	first := <-connected
+	session := readSession(t, conn)
+	require.Equal(t, uint64(0), session.Seq)
+	require.Equal(t, first.SessionToken(), session.Token)
+
+	first.Emit([]byte("one"))
+	first.Emit([]byte("two"))
+	require.Equal(t, []string{"one", "two"}, readMessages(t, conn, 2))
+
+	// the connection drops without a close frame
+	_ = conn.UnderlyingConn().Close()
+	<-disconnected
+
+	// the session is still reachable during the grace period
+	require.Nil(t, h.EmitTo(first.UUID, []byte("three")))
+	h.EmitToRoom("room", []byte("four"))
+	h.Broadcast([]byte("five"))
+	require.Equal(t, []string{first.UUID}, h.RoomMembers("room"))
+
+	// only the first message has been handled by the client
+	conn = dialSession(t, ln, session.Token, 1)
+	defer func() {
+		_ = conn.Close()
+	}()
+	second := <-connected
+	require.Equal(t, first.UUID, <-resumed)
+
+	require.True(t, second.Resumed())
+	require.Equal(t, first.UUID, second.GetUUID())
+	require.Equal(t, "test", second.GetStringAttribute("user"))
+	require.Equal(t, []string{"room"}, second.Rooms())
+
+	resumedSession := readSession(t, conn)
+	require.Equal(t, session.Token, resumedSession.Token)
+	require.Equal(t, uint64(1), resumedSession.Seq)
+	require.Equal(t, []string{"two", "three", "four", "five"}, readMessages(t, conn, 4))
+
+	// the sequence continues
+	second.Emit([]byte("six"))
+	require.Equal(t, []string{"six"}, readMessages(t, conn, 1))
+	_, messages := second.session.since(5)
+	require.Len(t, messages, 1)
+	require.Equal(t, uint64(6), messages[0].seq)
+}
+
+func TestSessionExpire(t *testing.T) {
+	h := NewHub(Config{SessionTTL: 50 * time.Millisecond})
+
+	connected := make(chan *Websocket, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		kws.Join("room")
+		connected <- kws
+	}))
+	defer shutdown()
+
+	left := make(chan string, 1)
+	h.On(EventLeave, func(payload *EventPayload) {
+		left <- payload.SocketUUID
+	})
+
+	conn := dialSession(t, ln, "", 0)
+	first := <-connected
+	session := readSession(t, conn)
+	_ = conn.UnderlyingConn().Close()
+
+	// the rooms are released when the grace period ends
+	require.Equal(t, first.UUID, <-left)
+	require.Empty(t, h.RoomMembers("room"))
+	require.Equal(t, ErrorInvalidConnection, h.EmitTo(first.UUID, []byte("lost")))
+
+	conn = dialSession(t, ln, session.Token, 0)
+	defer func() {
+		_ = conn.Close()
+	}()
+	second := <-connected
+	require.False(t, second.Resumed())
+	require.NotEqual(t, first.UUID, second.UUID)
+	require.NotEqual(t, session.Token, readSession(t, conn).Token)
+}
+
+func TestSessionClosed(t *testing.T) {
+	h := NewHub(Config{SessionTTL: 5 * time.Second})
+
+	connected := make(chan *Websocket, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		connected <- kws
+	}))
+	defer shutdown()
+
+	disconnected := make(chan struct{}, 1)
+	h.On(EventDisconnect, func(payload *EventPayload) {
+		disconnected <- struct{}{}
+	})
+
+	conn := dialSession(t, ln, "", 0)
+	defer func() {
+		_ = conn.Close()
+	}()
+	kws := <-connected
+	readSession(t, conn)
+
+	// a normal closure of the client ends the session
+	require.Nil(t, conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(int(CloseNormalClosure), "")))
+	<-disconnected
+	require.Equal(t, ErrorInvalidConnection, h.EmitTo(kws.UUID, []byte("lost")))
+}
+
+func TestSessionOutbox(t *testing.T) {
+	s := newSession(2)
+	for i := 0; i < 4; i++ {
+		s.record(TextMessage, []byte{byte(i)})
+	}
+
+	// the first two messages are no longer in the outbox
+	seq, messages := s.since(0)
+	require.Equal(t, uint64(2), seq)
+	require.Len(t, messages, 2)
+	require.Equal(t, uint64(3), messages[0].seq)
+
+	seq, messages = s.since(3)
+	require.Equal(t, uint64(3), seq)
+	require.Len(t, messages, 1)
+
+	seq, messages = s.since(10)
+	require.Equal(t, uint64(4), seq)
+	require.Empty(t, messages)
+}
+
+// Park a session of a disconnected connection that joined a room
+func parkTestSession(t *testing.T, h *Hub, uuid string) *session {
+	kws := createWS(withHub(h), withUUID(uuid), withDone())
+	kws.Join("room")
+	h.pool.delete(uuid)
+
+	s := newSession(10)
+	s.kws = kws
+	h.sessions.park(s, uuid, time.Minute, kws.expireSession)
+	return s
+}
+
+func TestSessionParkedEmits(t *testing.T) {
+	h := NewHub(Config{SessionTTL: time.Minute})
+	first := parkTestSession(t, h, "first")
+	second := parkTestSession(t, h, "second")
+
+	kws := createWS(withHub(h), withQueue(10), withDone())
+
+	// only the listed sessions keep the message, once
+	kws.EmitToList([]string{"first"}, []byte("list"))
+	_, messages := first.since(0)
+	require.Len(t, messages, 1)
+	_, messages = second.since(0)
+	require.Empty(t, messages)
+
+	kws.Broadcast([]byte("broadcast"), true)
+	_, messages = first.since(1)
+	require.Len(t, messages, 1)
+	_, messages = second.since(0)
+	require.Len(t, messages, 1)
+}
+
+func TestSessionTakeover(t *testing.T) {
+	h := NewHub(Config{SessionTTL: time.Minute})
+
+	// the uuid taken by another connection ends the session
+	s := parkTestSession(t, h, "parked")
+	kws := createWS(withHub(h), withDone())
+	kws.SetUUID("parked")
+	require.Nil(t, h.sessions.byToken(s.token))
+	require.Empty(t, h.RoomMembers("room"))
+
+	// the previous queue is not drained in time
+	s = parkTestSession(t, h, "stuck")
+	resumed := createWS()
+	resumed.hub = h
+	resumed.config = configDefault(Config{SessionTTL: time.Minute, CloseTimeout: 10 * time.Millisecond})
+	resumed.queue = make(chan message, 10)
+	resumed.openSession(s.token, "0")
+	require.False(t, resumed.Resumed())
+	require.NotEqual(t, s.token, resumed.SessionToken())
+	require.Nil(t, h.sessions.byToken(s.token))
+	require.Empty(t, h.RoomMembers("room"))
+}
+
+func TestSessionIdentity(t *testing.T) {
+	h := NewHub(Config{
+		SessionTTL: 5 * time.Second,
+		Authenticate: func(c *fiber.Ctx) (Identity, error) {
+			return Identity{UserID: c.Query("user")}, nil
+		},
+	})
+
+	connected := make(chan *Websocket, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		connected <- kws
+	}))
+	defer shutdown()
+
+	disconnected := make(chan struct{}, 1)
+	h.On(EventDisconnect, func(payload *EventPayload) {
+		disconnected <- struct{}{}
+	})
+
+	conn, _ := dialAuth(t, ln, "?user=alice")
+	first := <-connected
+	session := readSession(t, conn)
+	_ = conn.UnderlyingConn().Close()
+	<-disconnected
+
+	// the token of another user does not resume the session
+	resume := fmt.Sprintf("&%s=%s&%s=0", SessionTokenQuery, session.Token, SessionSeqQuery)
+	conn, _ = dialAuth(t, ln, "?user=bob"+resume)
+	other := <-connected
+	require.False(t, other.Resumed())
+	require.NotEqual(t, first.UUID, other.GetUUID())
+	require.Equal(t, "bob", other.UserID())
+	require.NotEqual(t, session.Token, readSession(t, conn).Token)
+	_ = conn.Close()
+
+	conn, _ = dialAuth(t, ln, "?user=alice"+resume)
+	defer func() {
+		_ = conn.Close()
+	}()
+	second := <-connected
+	require.True(t, second.Resumed())
+	require.Equal(t, first.UUID, second.GetUUID())
+	require.Equal(t, "alice", second.UserID())
+}
@@ -0,0 +1,115 @@
+package ikisocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+// Reason of the close frame sent on shutdown
+const shutdownReason = "server shutdown"
+
+// Shutdown Gracefully close the connections of the hub.
+// The new upgrades are rejected with 503 Service Unavailable, every
+// connection receives a 1001 Going Away close frame after the messages
+// already in its queue. Shutdown returns when the connections have been
+// disconnected and their EventDisconnect listeners completed, or when the
+// context expires: the remaining connections are then disconnected with
+// the context error, which is returned. The worker pools of DispatchPool
+// are stopped after the events already queued.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closing = true
+	if h.stopDirectory != nil {
+		close(h.stopDirectory)
+		h.stopDirectory = nil
+	}
+	presence := h.presence
+	h.mu.Unlock()
+
+	if presence != nil {
+		presence.close()
+	}
+
+	// a connection blocked on its full queue must not delay the others
+	for _, conn := range h.pool.all() {
+		if kws, ok := conn.(*Websocket); ok {
+			go kws.goingAway(ctx)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.running.Wait()
+		close(done)
+	}()
+
+	// the workers complete the events already queued
+	defer h.stopWorkers()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	// the connections may have completed at the deadline
+	select {
+	case <-done:
+		return nil
+	default:
+	}
+	for _, conn := range h.pool.all() {
+		conn.disconnected(ctx.Err())
+	}
+	return ctx.Err()
+}
+
+// Stop the worker pools of the endpoints
+func (h *Hub) stopWorkers() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, workers := range h.workers {
+		workers.stop()
+	}
+}
+
+// Returns false if the hub is shutting down
+func (h *Hub) accepting() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return !h.closing
+}
+
+// Track a running connection, returns false if the hub is shutting down.
+// Tracking under the lock ensures no connection is added after
+// Shutdown started waiting.
+func (h *Hub) track() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closing {
+		return false
+	}
+	h.running.Add(1)
+	return true
+}
+
+// Queue a going away close frame after the pending messages
+func (kws *Websocket) goingAway(ctx context.Context) {
+	_ = kws.closeWithCode(ctx, CloseGoingAway, shutdownReason)
+}
+
+// Refuse a connection upgraded while the hub is shutting down
+func refuse(c *websocket.Conn) {
+	_ = c.WriteControl(
+		CloseMessage,
+		websocket.FormatCloseMessage(int(CloseGoingAway), shutdownReason),
+		time.Now().Add(time.Second),
+	)
+}
+
+// Shutdown Gracefully close the connections of the default hub
+func Shutdown(ctx context.Context) error {
+	return defaultHub.Shutdown(ctx)
+}
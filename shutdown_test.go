@@ -0,0 +1,152 @@
+package ikisocket
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHubShutdown(t *testing.T) {
+	h := NewHub()
+
+	var disconnected int32
+	h.On(EventDisconnect, func(payload *EventPayload) {
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&disconnected, 1)
+	})
+
+	connected := make(chan *Websocket, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		connected <- kws
+	}))
+	defer shutdown()
+
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+	kws := <-connected
+
+	// the queued messages are sent before the close frame
+	kws.Emit([]byte("first"))
+	kws.Emit([]byte("second"))
+
+	received := make(chan []string, 1)
+	closeCode := make(chan int, 1)
+	go func() {
+		var messages []string
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				if closeErr, ok := err.(*websocket.CloseError); ok {
+					closeCode <- closeErr.Code
+				}
+				received <- messages
+				return
+			}
+			messages = append(messages, string(msg))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.Nil(t, h.Shutdown(ctx))
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&disconnected))
+	require.Empty(t, h.pool.all())
+	require.Equal(t, []string{"first", "second"}, <-received)
+	require.Equal(t, websocket.CloseGoingAway, <-closeCode)
+
+	// the new upgrades are rejected
+	dialer := &websocket.Dialer{NetDial: func(network, addr string) (net.Conn, error) {
+		return ln.Dial()
+	}}
+	_, resp, err := dialer.Dial("ws://"+ln.Addr().String(), nil)
+	require.NotNil(t, err)
+	require.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestHubShutdownTimeout(t *testing.T) {
+	h := NewHub()
+
+	disconnects := make(chan error, 1)
+	h.On(EventDisconnect, func(payload *EventPayload) {
+		disconnects <- payload.Error
+	})
+
+	connected := make(chan struct{}, 1)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		connected <- struct{}{}
+	}))
+	defer shutdown()
+
+	// the client never reads, the close frame is not answered
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+	<-connected
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, h.Shutdown(ctx))
+	require.Equal(t, context.DeadlineExceeded, <-disconnects)
+	require.Empty(t, h.pool.all())
+}
+
+func TestHubShutdownStalled(t *testing.T) {
+	h := NewHub()
+
+	connected := make(chan struct{}, 10)
+	ln, shutdown := startTestServer(h.New(func(kws *Websocket) {
+		connected <- struct{}{}
+	}))
+	defer shutdown()
+
+	closeCodes := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		conn := dialTestServer(t, ln)
+		defer func() {
+			_ = conn.Close()
+		}()
+		<-connected
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					code := 0
+					if closeErr, ok := err.(*websocket.CloseError); ok {
+						code = closeErr.Code
+					}
+					closeCodes <- code
+					return
+				}
+			}
+		}()
+	}
+
+	// a connection whose send loop is stuck with a full queue
+	stalled := createWS(withHub(h), withConfig(Config{OverflowPolicy: OverflowBlock}), withQueue(1), withDone())
+	stalled.queue <- message{mType: TextMessage}
+	require.True(t, h.track())
+	go func() {
+		<-stalled.done
+		h.running.Done()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, h.Shutdown(ctx))
+
+	// the healthy connections completed the close handshake
+	for i := 0; i < 10; i++ {
+		require.Equal(t, websocket.CloseGoingAway, <-closeCodes)
+	}
+	require.False(t, stalled.IsAlive())
+	require.Empty(t, h.pool.all())
+}
@@ -0,0 +1,101 @@
+package ikisocket
+
+// SetUserID Associate the connection to a user until its disconnection,
+// an empty user id removes the association. A user may have several
+// connections, all reached by EmitToUser and tracked by the presence.
+func (kws *Websocket) SetUserID(userID string) {
+	// serialized with the untrack of the disconnection, so the user
+	// of a dead connection is never left in the index or the presence
+	kws.userMu.Lock()
+	if userID != "" && !kws.IsAlive() {
+		kws.userMu.Unlock()
+		return
+	}
+
+	kws.mu.Lock()
+	previous := kws.userID
+	kws.userID = userID
+	kws.mu.Unlock()
+
+	if previous == userID {
+		kws.userMu.Unlock()
+		return
+	}
+
+	h := kws.getHub()
+	h.pool.user(kws.GetUUID(), userID)
+
+	p := h.Presence()
+	var offline string
+	var removed bool
+	if previous != "" {
+		offline, removed = p.remove(kws, previous)
+	}
+	var online string
+	if userID != "" {
+		online = p.add(kws, userID)
+	}
+	kws.userMu.Unlock()
+
+	// the listeners run without the lock, they may change the user
+	if removed {
+		p.notify(kws, previous, offline)
+	}
+	if userID != "" {
+		p.notify(kws, userID, online)
+	}
+}
+
+// UserID The user of the connection, empty if it is not set
+func (kws *Websocket) UserID() string {
+	kws.mu.RLock()
+	defer kws.mu.RUnlock()
+	return kws.userID
+}
+
+// EmitToUser Emit to all the connections of the user. With an adapter
+// the connections of the user on the other nodes are reached too.
+// Returns ErrorInvalidConnection if the user has no connection on this
+// node and there is no adapter.
+func (h *Hub) EmitToUser(userID string, message []byte, mType ...int) error {
+	sent := h.emitToUserLocal(userID, message, mType...)
+	if h.getAdapter() == nil {
+		if !sent {
+			return ErrorInvalidConnection
+		}
+		return nil
+	}
+
+	return h.publish(AdapterMessage{
+		Kind:   AdapterUser,
+		Target: userID,
+		Data:   message,
+		MType:  messageType(mType),
+	})
+}
+
+// Emit to the connections of the user in the local pool,
+// returns false if the user has no connection
+func (h *Hub) emitToUserLocal(userID string, message []byte, mType ...int) bool {
+	sent := false
+	for _, wsUUID := range h.pool.byUser(userID) {
+		if h.emitLocal(wsUUID, message, mType...) == nil {
+			sent = true
+		}
+	}
+	return sent
+}
+
+// EmitToUser Emit to all the connections of the user
+func (kws *Websocket) EmitToUser(userID string, message []byte, mType ...int) error {
+	err := kws.getHub().EmitToUser(userID, message, mType...)
+	if err != nil {
+		kws.fireEvent(EventError, []byte(userID), err)
+	}
+	return err
+}
+
+// EmitToUser Emit to all the connections of the user
+func EmitToUser(userID string, message []byte, mType ...int) error {
+	return defaultHub.EmitToUser(userID, message, mType...)
+}
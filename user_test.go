@@ -0,0 +1,106 @@
+package ikisocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmitToUser(t *testing.T) {
+	h := NewHub()
+
+	first := createWS(withHub(h), withDone(), withQueue(10))
+	second := createWS(withHub(h), withDone(), withQueue(10))
+	other := createWS(withHub(h), withDone(), withQueue(10))
+
+	first.SetUserID("alice")
+	second.SetUserID("alice")
+	other.SetUserID("bob")
+	require.Equal(t, "alice", first.UserID())
+	require.ElementsMatch(t, []string{first.UUID, second.UUID}, h.pool.byUser("alice"))
+
+	// every connection of the user receives the message
+	require.Nil(t, h.EmitToUser("alice", []byte("test")))
+	require.Equal(t, "test", string((<-first.queue).data))
+	require.Equal(t, "test", string((<-second.queue).data))
+	require.Empty(t, other.queue)
+
+	require.Equal(t, ErrorInvalidConnection, h.EmitToUser("carol", []byte("test")))
+
+	// the index follows the uuid of the connection
+	second.SetUUID("renamed")
+	require.ElementsMatch(t, []string{first.UUID, "renamed"}, h.pool.byUser("alice"))
+
+	first.disconnected(nil)
+	require.Equal(t, []string{"renamed"}, h.pool.byUser("alice"))
+	require.Nil(t, h.EmitToUser("alice", []byte("test")))
+	require.Equal(t, "test", string((<-second.queue).data))
+
+	// disconnected connections are not indexed again
+	first.SetUserID("bob")
+	require.Equal(t, []string{other.UUID}, h.pool.byUser("bob"))
+
+	second.SetUserID("")
+	require.Empty(t, second.UserID())
+	require.Empty(t, h.pool.byUser("alice"))
+	require.Equal(t, ErrorInvalidConnection, h.EmitToUser("alice", []byte("test")))
+}
+
+func TestSetUserIDDisconnected(t *testing.T) {
+	h := NewHub()
+
+	for i := 0; i < numTestConn; i++ {
+		kws := createWS(withHub(h), withDone(), withQueue(10))
+		go kws.disconnected(nil)
+		kws.SetUserID("alice")
+	}
+
+	// the users of the dead connections are removed
+	require.Eventually(t, func() bool {
+		return !h.Presence().Online("alice") && len(h.pool.byUser("alice")) == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestEmitToUserCluster(t *testing.T) {
+	a, connA, b, connB := createClusterTest(t)
+
+	b.pool.user(connB.UUID, "alice")
+
+	connB.wg.Add(1)
+	require.Nil(t, a.EmitToUser("alice", []byte("test")))
+	connB.wg.Wait()
+
+	connA.AssertNumberOfCalls(t, "Emit", 0)
+	connB.AssertNumberOfCalls(t, "Emit", 1)
+}
+
+func TestUserIDLocal(t *testing.T) {
+	h := NewHub()
+
+	connected := make(chan *Websocket, 1)
+	handler := h.New(func(kws *Websocket) {
+		connected <- kws
+	}, Config{UserIDLocal: "user"})
+
+	ln, shutdown := startTestServer(func(c *fiber.Ctx) error {
+		c.Locals("user", "alice")
+		return handler(c)
+	})
+	defer shutdown()
+
+	conn := dialTestServer(t, ln)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	kws := <-connected
+	require.Equal(t, "alice", kws.UserID())
+	require.True(t, h.Presence().Online("alice"))
+
+	require.Nil(t, h.EmitToUser("alice", []byte("test")))
+	_, msg, err := conn.ReadMessage()
+	require.Nil(t, err)
+	require.Equal(t, "test", string(msg))
+}